@@ -0,0 +1,238 @@
+/*
+ * Teleport
+ * Copyright (C) 2023  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package types
+
+import "github.com/gravitational/trace"
+
+// CAProvisionerType identifies which concrete CAProvisioner variant a
+// provisioner record holds, so it can be marshaled with its kind tagged
+// and dispatched to the right signer at the point of use.
+type CAProvisionerType string
+
+const (
+	// CAProvisionerTypeACME issues certificates through an external ACME
+	// (RFC 8555) certificate authority, completing an order/challenge
+	// flow instead of signing locally.
+	CAProvisionerTypeACME CAProvisionerType = "acme"
+	// CAProvisionerTypeJWK signs locally using a JSON Web Key held
+	// inline in the provisioner record.
+	CAProvisionerTypeJWK CAProvisionerType = "jwk"
+	// CAProvisionerTypeKMS signs using a key held in an external key
+	// management service; the provisioner record only names the key,
+	// the private material never leaves the KMS.
+	CAProvisionerTypeKMS CAProvisionerType = "kms"
+	// CAProvisionerTypeNebula issues Nebula certificates instead of
+	// X.509, for clusters that use Nebula as their overlay network.
+	CAProvisionerTypeNebula CAProvisionerType = "nebula"
+)
+
+// CAProvisioner is an external issuer attached to a CertAuthority: a way
+// of obtaining certificates signed by something other than the CA's own
+// in-backend SigningKeys, e.g. a public ACME authority, a key sitting in
+// a KMS/HSM, or a Nebula CA. Concrete variants are ACMEProvisioner,
+// JWKProvisioner, KMSProvisioner and NebulaProvisioner.
+type CAProvisioner interface {
+	// GetName returns the provisioner's name, unique among the
+	// provisioners attached to a single CA.
+	GetName() string
+	// GetProvisionerType identifies the concrete variant, so callers can
+	// type-switch on it (or tag it when marshaling) without a type
+	// assertion against every variant in turn.
+	GetProvisionerType() CAProvisionerType
+	// GetRevision returns the revision of this resource.
+	GetRevision() string
+	// SetRevision sets the revision of this resource.
+	SetRevision(revision string)
+	// GetResourceID returns the backend-assigned resource ID.
+	GetResourceID() int64
+	// SetResourceID sets the backend-assigned resource ID.
+	SetResourceID(id int64)
+	// CheckAndSetDefaults validates the provisioner and fills in any
+	// unset fields that have a sensible default.
+	CheckAndSetDefaults() error
+}
+
+// CAProvisionerMetadata holds the bookkeeping fields common to every
+// CAProvisioner variant. It's embedded by each concrete type rather than
+// duplicated, the same way resource headers are shared elsewhere.
+type CAProvisionerMetadata struct {
+	// Name is the provisioner's name.
+	Name string `json:"name"`
+	// Revision is the backend revision of this resource.
+	Revision string `json:"revision,omitempty"`
+	// ResourceID is the backend-assigned resource ID of this resource.
+	ResourceID int64 `json:"resource_id,omitempty"`
+}
+
+// GetName returns the provisioner's name.
+func (m *CAProvisionerMetadata) GetName() string { return m.Name }
+
+// GetRevision returns the revision of this resource.
+func (m *CAProvisionerMetadata) GetRevision() string { return m.Revision }
+
+// SetRevision sets the revision of this resource.
+func (m *CAProvisionerMetadata) SetRevision(revision string) { m.Revision = revision }
+
+// GetResourceID returns the backend-assigned resource ID.
+func (m *CAProvisionerMetadata) GetResourceID() int64 { return m.ResourceID }
+
+// SetResourceID sets the backend-assigned resource ID.
+func (m *CAProvisionerMetadata) SetResourceID(id int64) { m.ResourceID = id }
+
+// checkName is the part of CheckAndSetDefaults shared by every variant.
+func (m *CAProvisionerMetadata) checkName() error {
+	if m.Name == "" {
+		return trace.BadParameter("CA provisioner name is required")
+	}
+	return nil
+}
+
+// ACMEProvisioner issues certificates through an external ACME
+// certificate authority such as Let's Encrypt or a private ACME server.
+type ACMEProvisioner struct {
+	CAProvisionerMetadata
+	Spec ACMEProvisionerSpec `json:"spec"`
+}
+
+// ACMEProvisionerSpec is the configuration of an ACMEProvisioner.
+type ACMEProvisionerSpec struct {
+	// DirectoryURL is the ACME server's directory endpoint.
+	DirectoryURL string `json:"directory_url"`
+	// EABKeyID and EABHMACKey carry the optional External Account
+	// Binding credentials some ACME servers require before they'll
+	// issue an order for an account they don't already know.
+	EABKeyID   string `json:"eab_key_id,omitempty"`
+	EABHMACKey []byte `json:"eab_hmac_key,omitempty"`
+}
+
+// GetProvisionerType returns CAProvisionerTypeACME.
+func (p *ACMEProvisioner) GetProvisionerType() CAProvisionerType { return CAProvisionerTypeACME }
+
+// CheckAndSetDefaults validates p.
+func (p *ACMEProvisioner) CheckAndSetDefaults() error {
+	if err := p.checkName(); err != nil {
+		return trace.Wrap(err)
+	}
+	if p.Spec.DirectoryURL == "" {
+		return trace.BadParameter("ACME provisioner %q: directory_url is required", p.Name)
+	}
+	if (p.Spec.EABKeyID == "") != (len(p.Spec.EABHMACKey) == 0) {
+		return trace.BadParameter("ACME provisioner %q: eab_key_id and eab_hmac_key must be set together", p.Name)
+	}
+	return nil
+}
+
+// JWKProvisioner signs locally using a JSON Web Key held inline in the
+// provisioner record.
+type JWKProvisioner struct {
+	CAProvisionerMetadata
+	Spec JWKProvisionerSpec `json:"spec"`
+}
+
+// JWKProvisionerSpec is the configuration of a JWKProvisioner.
+type JWKProvisionerSpec struct {
+	// PrivateKey is the PEM-encoded private key used to sign
+	// certificates issued through this provisioner.
+	PrivateKey []byte `json:"private_key"`
+	// Certificate is the PEM-encoded issuer certificate matching
+	// PrivateKey. Because a JWK provisioner replaces the CA's own
+	// signing key rather than borrowing it, it must bring its own
+	// issuer certificate for signed leaves to chain to.
+	Certificate []byte `json:"certificate"`
+}
+
+// GetProvisionerType returns CAProvisionerTypeJWK.
+func (p *JWKProvisioner) GetProvisionerType() CAProvisionerType { return CAProvisionerTypeJWK }
+
+// CheckAndSetDefaults validates p.
+func (p *JWKProvisioner) CheckAndSetDefaults() error {
+	if err := p.checkName(); err != nil {
+		return trace.Wrap(err)
+	}
+	if len(p.Spec.PrivateKey) == 0 {
+		return trace.BadParameter("JWK provisioner %q: private_key is required", p.Name)
+	}
+	if len(p.Spec.Certificate) == 0 {
+		return trace.BadParameter("JWK provisioner %q: certificate is required", p.Name)
+	}
+	return nil
+}
+
+// KMSProvisioner signs using a key held in an external key management
+// service; the private material never leaves the KMS.
+type KMSProvisioner struct {
+	CAProvisionerMetadata
+	Spec KMSProvisionerSpec `json:"spec"`
+}
+
+// KMSProvisionerSpec is the configuration of a KMSProvisioner.
+type KMSProvisionerSpec struct {
+	// KeyID identifies the signing key within the KMS, e.g. a GCP KMS
+	// CryptoKeyVersion resource name or an AWS KMS key ARN.
+	KeyID string `json:"key_id"`
+	// Certificate is the PEM-encoded issuer certificate matching the
+	// KMS-held key, for the same reason JWKProvisionerSpec carries one.
+	Certificate []byte `json:"certificate"`
+}
+
+// GetProvisionerType returns CAProvisionerTypeKMS.
+func (p *KMSProvisioner) GetProvisionerType() CAProvisionerType { return CAProvisionerTypeKMS }
+
+// CheckAndSetDefaults validates p.
+func (p *KMSProvisioner) CheckAndSetDefaults() error {
+	if err := p.checkName(); err != nil {
+		return trace.Wrap(err)
+	}
+	if p.Spec.KeyID == "" {
+		return trace.BadParameter("KMS provisioner %q: key_id is required", p.Name)
+	}
+	if len(p.Spec.Certificate) == 0 {
+		return trace.BadParameter("KMS provisioner %q: certificate is required", p.Name)
+	}
+	return nil
+}
+
+// NebulaProvisioner issues Nebula certificates instead of X.509, signed
+// by a Nebula CA key held inline in the provisioner record.
+type NebulaProvisioner struct {
+	CAProvisionerMetadata
+	Spec NebulaProvisionerSpec `json:"spec"`
+}
+
+// NebulaProvisionerSpec is the configuration of a NebulaProvisioner.
+type NebulaProvisionerSpec struct {
+	// CAKey is the PEM-encoded Nebula CA private key.
+	CAKey []byte `json:"ca_key"`
+	// CACert is the PEM-encoded Nebula CA certificate.
+	CACert []byte `json:"ca_cert"`
+}
+
+// GetProvisionerType returns CAProvisionerTypeNebula.
+func (p *NebulaProvisioner) GetProvisionerType() CAProvisionerType { return CAProvisionerTypeNebula }
+
+// CheckAndSetDefaults validates p.
+func (p *NebulaProvisioner) CheckAndSetDefaults() error {
+	if err := p.checkName(); err != nil {
+		return trace.Wrap(err)
+	}
+	if len(p.Spec.CAKey) == 0 || len(p.Spec.CACert) == 0 {
+		return trace.BadParameter("Nebula provisioner %q: ca_key and ca_cert are required", p.Name)
+	}
+	return nil
+}
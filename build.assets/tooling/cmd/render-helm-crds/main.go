@@ -2,17 +2,60 @@ package main
 
 import (
 	"flag"
-	"github.com/gravitational/trace"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/gravitational/trace"
+	"gopkg.in/yaml.v2"
+)
+
+// installMode controls when a rendered CRD template actually installs the
+// CRD, mirroring the three choices cert-manager-style Helm charts expose
+// to operators.
+type installMode string
+
+const (
+	// installAlways renders the CRD unconditionally.
+	installAlways installMode = "always"
+	// installDynamic is the original behavior of this tool: the CRD is
+	// installed if .Values.enabled is true, or if a CRD of the same
+	// name is already present in the cluster (so upgrades of a chart
+	// that previously installed it don't silently drop it).
+	installDynamic installMode = "dynamic"
+	// installNever skips rendering the CRD entirely.
+	installNever installMode = "never"
 )
 
+// manifestFileName is the sidecar YAML file, living alongside the CRD
+// source files, that maps a CRD file name to its crdConfig. It is never
+// itself treated as a CRD to render.
+const manifestFileName = "crds.yaml"
+
+// crdConfig is the per-CRD configuration read from the sidecar manifest.
+type crdConfig struct {
+	Mode installMode `yaml:"mode"`
+}
+
+// manifest maps a CRD source file name (e.g. "resources.teleport.dev_githubconnectors.yaml")
+// to its crdConfig. CRDs with no entry fall back to the tool's -default-mode flag.
+type manifest map[string]crdConfig
+
 const (
-	helmCRDPrefix = `{{/*
-  Deploy the CRD if 'installCRDs' is set to "always", or if 'installCRD' is set
-  to "dynamic" and either 'enabled' is true or the CRD is already present.
+	hookAnnotations = `  annotations:
+    "helm.sh/hook": crd-install
+    "helm.sh/resource-policy": keep
+`
+	crdSuffix = `{{- end }}
+`
+)
+
+const dynamicPrefixTemplate = `{{/*
+  Deploy the CRD if 'installCRDs' is set to "always", or if 'installCRDs' is
+  set to "dynamic" and either 'enabled' is true or the CRD is already
+  present.
 */}}
 {{- if or
   (eq .Values.installCRDs "always")
@@ -20,20 +63,24 @@ const (
     (eq .Values.installCRDs "dynamic")
     (or
       .Values.enabled
-      (lookup "apiextensions.k8s.io/v1" "CustomResourceDefinition" "" "teleportgithubconnectors.resources.teleport.dev")
+      (lookup "apiextensions.k8s.io/v1" "CustomResourceDefinition" "" %q)
     )
   )
 }}
 `
-	helmCRDSuffix = `{{- end }}
+
+const alwaysPrefixTemplate = `{{/*
+  Always deploy this CRD.
+*/}}
 `
-)
 
 func main() {
 	var sourceDir string
 	var destDir string
+	var defaultMode string
 	flag.StringVar(&sourceDir, "source", "", "Source directory containing the CRDs.")
 	flag.StringVar(&destDir, "destination", "", "Destination directory, the Helm chart template directory.")
+	flag.StringVar(&defaultMode, "default-mode", string(installDynamic), "Install mode to use for CRDs with no entry in crds.yaml: always, dynamic, or never.")
 	flag.Parse()
 
 	if sourceDir == "" {
@@ -42,25 +89,41 @@ func main() {
 	if destDir == "" {
 		log.Fatalln("destination flag must be specified")
 	}
-	err := run(sourceDir, destDir)
-	if err != nil {
+	if err := validateMode(installMode(defaultMode)); err != nil {
 		log.Fatalln(err)
 	}
 
+	err := runWithDefaultMode(sourceDir, destDir, installMode(defaultMode))
+	if err != nil {
+		log.Fatalln(err)
+	}
 }
 
+// run renders every CRD in sourceDir into destDir, using installDynamic
+// for any CRD without an entry in the sidecar manifest. It exists
+// alongside runWithDefaultMode for callers (and tests) that don't need to
+// override the fallback mode.
 func run(sourceDir, destDir string) error {
+	return runWithDefaultMode(sourceDir, destDir, installDynamic)
+}
+
+func runWithDefaultMode(sourceDir, destDir string, defaultMode installMode) error {
 	crds, err := readCRDs(sourceDir)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
-	err = writeCrds(destDir, crds)
+	m, err := loadManifest(sourceDir)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
-	log.Printf("%d CRDs written\n", len(crds))
+	written, err := writeCrds(destDir, crds, m, defaultMode)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	log.Printf("%d CRDs written\n", written)
 	return nil
 }
 
@@ -79,7 +142,7 @@ func readCRDs(sourceDir string) (map[string][]byte, error) {
 	}
 
 	for _, v := range files {
-		if v.IsDir() || !strings.HasSuffix(v.Name(), ".yaml") {
+		if v.IsDir() || !strings.HasSuffix(v.Name(), ".yaml") || v.Name() == manifestFileName {
 			continue
 		}
 		fullPath := filepath.Join(sourceDir, v.Name())
@@ -93,40 +156,130 @@ func readCRDs(sourceDir string) (map[string][]byte, error) {
 	return crds, nil
 }
 
-func craftHelmCRD(originalCRD string) (string, error) {
-	sb := strings.Builder{}
-	_, err := sb.WriteString(helmCRDPrefix)
+// loadManifest reads the sidecar crds.yaml file, if present, mapping CRD
+// file names to their crdConfig. A missing manifest is not an error:
+// every CRD simply falls back to the caller's default mode.
+func loadManifest(sourceDir string) (manifest, error) {
+	content, err := os.ReadFile(filepath.Join(sourceDir, manifestFileName))
 	if err != nil {
-		return "", trace.Wrap(err)
+		if os.IsNotExist(err) {
+			return manifest{}, nil
+		}
+		return nil, trace.Errorf("failed to read %q: %s", manifestFileName, err)
 	}
 
-	escapedCRD := strings.ReplaceAll(originalCRD, "`{{", "{{ `{{")
-	escapedCRD = strings.ReplaceAll(escapedCRD, "}}`", "}}` }}")
-	_, err = sb.WriteString(escapedCRD)
-	if err != nil {
-		return "", trace.Wrap(err)
+	var m manifest
+	if err := yaml.Unmarshal(content, &m); err != nil {
+		return nil, trace.Errorf("failed to parse %q: %s", manifestFileName, err)
+	}
+	for name, cfg := range m {
+		if err := validateMode(cfg.Mode); err != nil {
+			return nil, trace.Errorf("%q: %s", name, err)
+		}
+	}
+	return m, nil
+}
+
+func validateMode(mode installMode) error {
+	switch mode {
+	case installAlways, installDynamic, installNever:
+		return nil
+	default:
+		return trace.BadParameter("unknown install mode %q, must be one of always, dynamic, never", mode)
 	}
+}
 
-	_, err = sb.WriteString(helmCRDSuffix)
-	if err != nil {
+// crdName extracts metadata.name from a CRD source file, so the "dynamic"
+// lookup call can be templated with the CRD's own gated resource name
+// instead of a value hardcoded for every file.
+func crdName(content []byte) (string, error) {
+	var parsed struct {
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}
+	if err := yaml.Unmarshal(content, &parsed); err != nil {
 		return "", trace.Wrap(err)
 	}
+	if parsed.Metadata.Name == "" {
+		return "", trace.BadParameter("CRD is missing metadata.name")
+	}
+	return parsed.Metadata.Name, nil
+}
+
+// craftHelmCRD wraps originalCRD in the Helm template machinery for the
+// given install mode, injecting the cert-manager-style crd-install /
+// resource-policy annotations so that `helm upgrade` never drops an
+// already-installed CRD. ok is false when mode is installNever, meaning
+// the CRD should not be written at all.
+func craftHelmCRD(originalCRD string, name string, mode installMode) (rendered string, ok bool, err error) {
+	if mode == installNever {
+		return "", false, nil
+	}
+
+	annotated := injectHookAnnotations(originalCRD)
+	escaped := escapeForHelm(annotated)
+
+	switch mode {
+	case installAlways:
+		// Always installed: no {{- if }}/{{- end }} gating needed.
+		return alwaysPrefixTemplate + escaped, true, nil
+	case installDynamic, "":
+		prefix := fmt.Sprintf(dynamicPrefixTemplate, name)
+		return prefix + escaped + crdSuffix, true, nil
+	default:
+		return "", false, trace.BadParameter("unknown install mode %q", mode)
+	}
+}
+
+// injectHookAnnotations adds the standard helm.sh/hook: crd-install and
+// helm.sh/resource-policy: keep annotations right after the CRD's
+// metadata: block, so the CRD survives `helm upgrade` even when the
+// release that installed it is later uninstalled.
+func injectHookAnnotations(originalCRD string) string {
+	const marker = "\nmetadata:\n"
+	idx := strings.Index(originalCRD, marker)
+	if idx == -1 {
+		return originalCRD
+	}
+	insertAt := idx + len(marker)
+	return originalCRD[:insertAt] + hookAnnotations + originalCRD[insertAt:]
+}
 
-	return sb.String(), nil
+func escapeForHelm(originalCRD string) string {
+	escaped := strings.ReplaceAll(originalCRD, "`{{", "{{ `{{")
+	escaped = strings.ReplaceAll(escaped, "}}`", "}}` }}")
+	return escaped
 }
 
-func writeCrds(destDir string, crds map[string][]byte) error {
-	for crdName, crdContent := range crds {
-		helmCRDContent, err := craftHelmCRD(string(crdContent))
+func writeCrds(destDir string, crds map[string][]byte, m manifest, defaultMode installMode) (int, error) {
+	written := 0
+	for crdName_, crdContent := range crds {
+		mode := defaultMode
+		if cfg, ok := m[crdName_]; ok && cfg.Mode != "" {
+			mode = cfg.Mode
+		}
+
+		resourceName, err := crdName(crdContent)
 		if err != nil {
-			return trace.Errorf("failed to craft template for CRD %q: %s", crdName, err)
+			return written, trace.Errorf("failed to determine resource name for CRD %q: %s", crdName_, err)
 		}
-		fullPath := filepath.Join(destDir, crdName)
-		log.Printf("writing CRD file %q\n", fullPath)
-		err = os.WriteFile(fullPath, []byte(helmCRDContent), 0644)
+
+		helmCRDContent, ok, err := craftHelmCRD(string(crdContent), resourceName, mode)
 		if err != nil {
-			return trace.Errorf("failed to write file for CRD tempalte %s: %q", fullPath, err)
+			return written, trace.Errorf("failed to craft template for CRD %q: %s", crdName_, err)
+		}
+		if !ok {
+			log.Printf("skipping CRD file %q (install mode %q)\n", crdName_, mode)
+			continue
 		}
+
+		fullPath := filepath.Join(destDir, crdName_)
+		log.Printf("writing CRD file %q\n", fullPath)
+		if err := os.WriteFile(fullPath, []byte(helmCRDContent), 0644); err != nil {
+			return written, trace.Errorf("failed to write file for CRD tempalte %s: %q", fullPath, err)
+		}
+		written++
 	}
-	return nil
+	return written, nil
 }
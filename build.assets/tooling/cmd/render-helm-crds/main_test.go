@@ -1,10 +1,11 @@
 package main
 
 import (
-	"github.com/stretchr/testify/require"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -34,3 +35,77 @@ func TestSnapshot(t *testing.T) {
 		require.Equal(t, string(expectedContent), string(actualContent), "content does not match for file %s", expectedFile)
 	}
 }
+
+// TestCrdsManifestOverridesMode round-trips every CRD in testdata through
+// readCRDs/writeCrds and verifies crds.yaml's per-CRD modes win over
+// -default-mode: the "never" CRD is skipped and the "always" CRD isn't
+// gated on .Values.installCRDs.
+func TestCrdsManifestOverridesMode(t *testing.T) {
+	destDir := t.TempDir()
+
+	err := runWithDefaultMode(testDataDir, destDir, installDynamic)
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(destDir, "resources.teleport.dev_legacy.yaml"))
+	require.True(t, os.IsNotExist(err), "CRD configured with mode: never should not be rendered")
+
+	alwaysContent, err := os.ReadFile(filepath.Join(destDir, "resources.teleport.dev_roles.yaml"))
+	require.NoError(t, err)
+	require.NotContains(t, string(alwaysContent), ".Values.installCRDs")
+
+	dynamicContent, err := os.ReadFile(filepath.Join(destDir, "resources.teleport.dev_githubconnectors.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(dynamicContent), `lookup "apiextensions.k8s.io/v1" "CustomResourceDefinition" "" "githubconnectors.resources.teleport.dev"`)
+}
+
+// TestCraftHelmCRDPerMode checks each install mode in isolation against a
+// single CRD, independent of the sidecar manifest.
+func TestCraftHelmCRDPerMode(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join(testDataDir, "resources.teleport.dev_githubconnectors.yaml"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		mode       installMode
+		wantOK     bool
+		wantSubstr string
+	}{
+		{mode: installAlways, wantOK: true, wantSubstr: "Always deploy this CRD"},
+		{mode: installDynamic, wantOK: true, wantSubstr: `lookup "apiextensions.k8s.io/v1" "CustomResourceDefinition" "" "githubconnectors.resources.teleport.dev"`},
+		{mode: installNever, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			rendered, ok, err := craftHelmCRD(string(original), "githubconnectors.resources.teleport.dev", tt.mode)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				require.Contains(t, rendered, tt.wantSubstr)
+				require.Contains(t, rendered, `"helm.sh/hook": crd-install`)
+				require.Contains(t, rendered, `"helm.sh/resource-policy": keep`)
+			}
+		})
+	}
+}
+
+// TestCrdNameUsesEachFilesOwnMetadataName guards against the tool
+// regressing to a single hardcoded gated resource name for every CRD.
+func TestCrdNameUsesEachFilesOwnMetadataName(t *testing.T) {
+	crds, err := readCRDs(testDataDir)
+	require.NoError(t, err)
+
+	want := map[string]string{
+		"resources.teleport.dev_githubconnectors.yaml": "githubconnectors.resources.teleport.dev",
+		"resources.teleport.dev_roles.yaml":            "roles.resources.teleport.dev",
+		"resources.teleport.dev_legacy.yaml":           "legacy.resources.teleport.dev",
+	}
+
+	names := make(map[string]bool, len(crds))
+	for fileName, content := range crds {
+		name, err := crdName(content)
+		require.NoError(t, err)
+		require.Equal(t, want[fileName], name)
+		require.False(t, names[name], "resource name %q reused across CRDs", name)
+		names[name] = true
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import "sync"
+
+// EventKind identifies the shape of an Event's Payload.
+type EventKind string
+
+const (
+	// EventMessageSent fires when a plugin posts a new message to a
+	// recipient.
+	EventMessageSent EventKind = "message_sent"
+	// EventMessageUpdated fires when a plugin edits a previously sent
+	// message (e.g. to reflect a new review).
+	EventMessageUpdated EventKind = "message_updated"
+	// EventReviewPosted fires when a reviewer's decision has been
+	// recorded against the request.
+	EventReviewPosted EventKind = "review_posted"
+	// EventRequestExpired fires when an access request's underlying
+	// messages were updated to reflect expiration.
+	EventRequestExpired EventKind = "request_expired"
+	// EventRecipientResolutionFailed fires when a plugin could not
+	// resolve a configured recipient to a real chat-backend identity.
+	EventRecipientResolutionFailed EventKind = "recipient_resolution_failed"
+	// EventDeliveryFailed fires when a message delivery exhausted its
+	// retries and was handed off to a dead-letter sink.
+	EventDeliveryFailed EventKind = "delivery_failed"
+)
+
+// Event is a single strongly-typed occurrence in an access-request
+// plugin's lifecycle.
+type Event struct {
+	// Kind identifies the shape of Payload.
+	Kind EventKind
+	// PluginName is the plugin that emitted the event, e.g. "msteams".
+	PluginName string
+	// RequestID is the Teleport access request the event concerns.
+	RequestID string
+	// RecipientID is the chat-backend identity the event concerns, if
+	// any (e.g. a Teams user ID).
+	RecipientID string
+	// Payload is the event's typed data, one of the Message*/Review*
+	// types declared by the emitting plugin.
+	Payload any
+}
+
+// Filter decides whether a subscriber is interested in an Event. A nil
+// Filter matches every event.
+type Filter func(Event) bool
+
+// subscriberBufferSize bounds the number of buffered events per
+// subscriber before the bus starts dropping the oldest ones. A slow
+// consumer loses history rather than blocking the hot path that emits
+// events.
+const subscriberBufferSize = 64
+
+// Bus fans out Events to subscribers, in order per RequestID, without
+// letting a slow subscriber block emission. It is safe for concurrent
+// use, and a Bus with no subscribers is a no-op sink: Emit never blocks
+// and never allocates a channel nobody reads from.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[*subscription]struct{}
+}
+
+// NewBus returns an empty, ready-to-use Bus.
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*subscription]struct{})}
+}
+
+type subscription struct {
+	filter Filter
+	ch     chan Event
+}
+
+// Subscribe registers a new subscriber matching filter (or every event,
+// if filter is nil) and returns a receive-only channel of matching
+// events plus an Unsubscribe func to release it.
+func (b *Bus) Subscribe(filter Filter) (events <-chan Event, unsubscribe func()) {
+	sub := &subscription{filter: filter, ch: make(chan Event, subscriberBufferSize)}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[sub]; ok {
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Emit delivers event to every matching subscriber. A subscriber whose
+// buffer is full has its oldest queued event dropped to make room,
+// rather than blocking the caller - ordering per RequestID is preserved
+// because drops only ever happen at the front of that subscriber's own
+// queue.
+func (b *Bus) Emit(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			select {
+			case <-sub.ch:
+			default:
+			}
+			select {
+			case sub.ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// ForRequest returns a Filter matching events for a single request ID.
+func ForRequest(requestID string) Filter {
+	return func(e Event) bool { return e.RequestID == requestID }
+}
+
+// ForPlugin returns a Filter matching events from a single plugin.
+func ForPlugin(pluginName string) Filter {
+	return func(e Event) bool { return e.PluginName == pluginName }
+}
@@ -0,0 +1,99 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusDeliversToMatchingSubscribers(t *testing.T) {
+	bus := NewBus()
+
+	all, unsubAll := bus.Subscribe(nil)
+	defer unsubAll()
+
+	mine, unsubMine := bus.Subscribe(ForRequest("req-1"))
+	defer unsubMine()
+
+	bus.Emit(Event{Kind: EventMessageSent, RequestID: "req-1"})
+	bus.Emit(Event{Kind: EventMessageSent, RequestID: "req-2"})
+
+	require.Len(t, drain(t, all, 2), 2)
+	require.Len(t, drain(t, mine, 1), 1)
+}
+
+func TestBusEmitWithNoSubscribersDoesNotBlock(t *testing.T) {
+	bus := NewBus()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			bus.Emit(Event{Kind: EventMessageSent, RequestID: "req-1"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Emit blocked with no subscribers")
+	}
+}
+
+func TestBusDropsOldestWhenSubscriberIsSlow(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe(nil)
+	defer unsubscribe()
+
+	// Flood well past the subscriber's buffer without ever reading.
+	for i := 0; i < subscriberBufferSize*2; i++ {
+		bus.Emit(Event{Kind: EventMessageSent, RequestID: "flood", Payload: i})
+	}
+
+	require.Len(t, events, subscriberBufferSize)
+	first := <-events
+	require.Equal(t, subscriberBufferSize, first.Payload, "the oldest events should have been dropped")
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	bus := NewBus()
+	events, unsubscribe := bus.Subscribe(nil)
+	unsubscribe()
+
+	_, ok := <-events
+	require.False(t, ok, "channel should be closed after Unsubscribe")
+}
+
+func drain(t *testing.T, ch <-chan Event, want int) []Event {
+	t.Helper()
+	var got []Event
+	for {
+		select {
+		case e := <-ch:
+			got = append(got, e)
+			if len(got) == want {
+				return got
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %d events, got %d", want, len(got))
+		}
+	}
+}
@@ -0,0 +1,220 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+)
+
+// Recipient is a single resolved message recipient, after the plugin has
+// turned a configured identifier (email, role, Teleport username, ...)
+// into a concrete chat-backend identity.
+type Recipient struct {
+	// ID is the chat-backend identifier to post to, e.g. a Teams user ID.
+	ID string
+	// Name is a human-readable label for logs and audit events.
+	Name string
+}
+
+// RecipientFilter is invoked after Recipients have been resolved to
+// concrete chat-backend identities and before the plugin posts anything
+// to them. A filter may drop recipients, or rewrite the list (e.g. to
+// substitute the next suggested reviewer for one who is unavailable).
+//
+// Filters are applied in the order the recipients were resolved, so a
+// filter that drops the primary candidate in an ordered list effectively
+// falls back to the next one.
+type RecipientFilter interface {
+	// FilterRecipients returns the recipients that should actually
+	// receive a message for requestID, derived from recipients.
+	FilterRecipients(ctx context.Context, requestID string, recipients []Recipient) ([]Recipient, error)
+}
+
+// RecipientFilterFunc adapts a plain function to a RecipientFilter.
+type RecipientFilterFunc func(ctx context.Context, requestID string, recipients []Recipient) ([]Recipient, error)
+
+// FilterRecipients implements RecipientFilter.
+func (f RecipientFilterFunc) FilterRecipients(ctx context.Context, requestID string, recipients []Recipient) ([]Recipient, error) {
+	return f(ctx, requestID, recipients)
+}
+
+// RecipientFilterChain applies a sequence of RecipientFilters in order,
+// feeding each one's output into the next.
+type RecipientFilterChain []RecipientFilter
+
+// FilterRecipients implements RecipientFilter.
+func (c RecipientFilterChain) FilterRecipients(ctx context.Context, requestID string, recipients []Recipient) ([]Recipient, error) {
+	for _, filter := range c {
+		var err error
+		recipients, err = filter.FilterRecipients(ctx, requestID, recipients)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return recipients, nil
+}
+
+// Presence is a recipient's current availability, as reported by e.g.
+// the Microsoft Graph /users/{id}/presence endpoint.
+type Presence string
+
+const (
+	// PresenceAvailable means the recipient can be reached right now.
+	PresenceAvailable Presence = "Available"
+	// PresenceBusy means the recipient is reachable but occupied.
+	PresenceBusy Presence = "Busy"
+	// PresenceOutOfOffice means the recipient has an active
+	// out-of-office/calendar entry and should be skipped in favor of a
+	// fallback reviewer.
+	PresenceOutOfOffice Presence = "OutOfOffice"
+	// PresenceUnknown is returned when a PresenceChecker has no opinion,
+	// e.g. the recipient doesn't support presence at all.
+	PresenceUnknown Presence = "Unknown"
+)
+
+// PresenceChecker looks up a recipient's current Presence.
+type PresenceChecker interface {
+	GetPresence(ctx context.Context, recipientID string) (Presence, error)
+}
+
+// RecipientFilterOption customizes a RecipientFilter returned by
+// NewPresenceFilter or NewAllowlistFilter.
+type RecipientFilterOption func(*filterEvents)
+
+// filterEvents is embedded by the concrete filters so emitting
+// EventRecipientResolutionFailed is a couple of shared lines instead of
+// being duplicated in every FilterRecipients implementation.
+type filterEvents struct {
+	bus        *Bus
+	pluginName string
+}
+
+// WithRecipientFilterBus makes a filter emit
+// EventRecipientResolutionFailed on bus, tagged as pluginName, whenever
+// it drops a recipient. Without this option a filter drops recipients
+// silently, exactly as before event emission existed.
+func WithRecipientFilterBus(bus *Bus, pluginName string) RecipientFilterOption {
+	return func(e *filterEvents) {
+		e.bus = bus
+		e.pluginName = pluginName
+	}
+}
+
+func (e *filterEvents) emitDropped(requestID string, recipient Recipient, reason string) {
+	if e.bus == nil {
+		return
+	}
+	e.bus.Emit(Event{
+		Kind:        EventRecipientResolutionFailed,
+		PluginName:  e.pluginName,
+		RequestID:   requestID,
+		RecipientID: recipient.ID,
+		Payload:     reason,
+	})
+}
+
+// PresenceFilter drops recipients who are PresenceOutOfOffice. Because
+// recipients are resolved in priority order (e.g. suggested reviewers
+// first), dropping an unavailable one effectively promotes the next
+// candidate already present in the list.
+type PresenceFilter struct {
+	checker PresenceChecker
+	events  filterEvents
+}
+
+// NewPresenceFilter returns a RecipientFilter that skips out-of-office
+// recipients as reported by checker.
+func NewPresenceFilter(checker PresenceChecker, opts ...RecipientFilterOption) *PresenceFilter {
+	f := &PresenceFilter{checker: checker}
+	for _, opt := range opts {
+		opt(&f.events)
+	}
+	return f
+}
+
+// FilterRecipients implements RecipientFilter. A checker error fails
+// open - i.e. keeps the recipient - rather than silently dropping a
+// reviewer because presence couldn't be determined.
+func (f *PresenceFilter) FilterRecipients(ctx context.Context, requestID string, recipients []Recipient) ([]Recipient, error) {
+	filtered := make([]Recipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		presence, err := f.checker.GetPresence(ctx, recipient.ID)
+		if err == nil && presence == PresenceOutOfOffice {
+			f.events.emitDropped(requestID, recipient, "recipient is out of office")
+			continue
+		}
+		filtered = append(filtered, recipient)
+	}
+	return filtered, nil
+}
+
+// RoleGetter resolves the Teleport roles currently granted to a
+// recipient, keyed by the same identifier used to message them.
+type RoleGetter interface {
+	GetRoles(ctx context.Context, recipientID string) ([]string, error)
+}
+
+// AllowlistFilter drops any recipient who doesn't hold at least one of
+// AllowedRoles, so that only reviewers Teleport itself authorizes for
+// this kind of request are ever messaged.
+type AllowlistFilter struct {
+	roles        RoleGetter
+	allowedRoles map[string]struct{}
+	events       filterEvents
+}
+
+// NewAllowlistFilter returns a RecipientFilter keeping only recipients
+// who hold at least one role in allowedRoles, as reported by roles.
+func NewAllowlistFilter(roles RoleGetter, allowedRoles []string, opts ...RecipientFilterOption) *AllowlistFilter {
+	set := make(map[string]struct{}, len(allowedRoles))
+	for _, role := range allowedRoles {
+		set[role] = struct{}{}
+	}
+	f := &AllowlistFilter{roles: roles, allowedRoles: set}
+	for _, opt := range opts {
+		opt(&f.events)
+	}
+	return f
+}
+
+// FilterRecipients implements RecipientFilter.
+func (f *AllowlistFilter) FilterRecipients(ctx context.Context, requestID string, recipients []Recipient) ([]Recipient, error) {
+	filtered := make([]Recipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		roles, err := f.roles.GetRoles(ctx, recipient.ID)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		allowed := false
+		for _, role := range roles {
+			if _, ok := f.allowedRoles[role]; ok {
+				allowed = true
+				break
+			}
+		}
+		if allowed {
+			filtered = append(filtered, recipient)
+		} else {
+			f.events.emitDropped(requestID, recipient, "recipient holds no allowed role")
+		}
+	}
+	return filtered, nil
+}
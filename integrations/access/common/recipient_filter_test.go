@@ -0,0 +1,136 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePresenceChecker map[string]Presence
+
+func (f fakePresenceChecker) GetPresence(_ context.Context, recipientID string) (Presence, error) {
+	if presence, ok := f[recipientID]; ok {
+		return presence, nil
+	}
+	return PresenceUnknown, nil
+}
+
+func TestPresenceFilterSkipsOutOfOffice(t *testing.T) {
+	filter := NewPresenceFilter(fakePresenceChecker{
+		"reviewer1": PresenceOutOfOffice,
+		"reviewer2": PresenceAvailable,
+	})
+
+	got, err := filter.FilterRecipients(context.Background(), "req-1", []Recipient{
+		{ID: "reviewer1", Name: "Reviewer One"},
+		{ID: "reviewer2", Name: "Reviewer Two"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Recipient{{ID: "reviewer2", Name: "Reviewer Two"}}, got)
+}
+
+func TestPresenceFilterFailsOpenOnCheckerError(t *testing.T) {
+	filter := NewPresenceFilter(presenceCheckerFunc(func(context.Context, string) (Presence, error) {
+		return "", trace.Errorf("graph api unavailable")
+	}))
+
+	got, err := filter.FilterRecipients(context.Background(), "req-1", []Recipient{{ID: "reviewer1"}})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+}
+
+type presenceCheckerFunc func(context.Context, string) (Presence, error)
+
+func (f presenceCheckerFunc) GetPresence(ctx context.Context, recipientID string) (Presence, error) {
+	return f(ctx, recipientID)
+}
+
+type fakeRoleGetter map[string][]string
+
+func (f fakeRoleGetter) GetRoles(_ context.Context, recipientID string) ([]string, error) {
+	return f[recipientID], nil
+}
+
+func TestAllowlistFilterKeepsOnlyAllowedRoles(t *testing.T) {
+	filter := NewAllowlistFilter(fakeRoleGetter{
+		"reviewer1": {"auditor"},
+		"reviewer2": {"access-reviewer"},
+	}, []string{"access-reviewer"})
+
+	got, err := filter.FilterRecipients(context.Background(), "req-1", []Recipient{
+		{ID: "reviewer1"},
+		{ID: "reviewer2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Recipient{{ID: "reviewer2"}}, got)
+}
+
+func TestPresenceFilterEmitsRecipientResolutionFailed(t *testing.T) {
+	bus := NewBus()
+	events, unsub := bus.Subscribe(nil)
+	defer unsub()
+
+	filter := NewPresenceFilter(fakePresenceChecker{
+		"reviewer1": PresenceOutOfOffice,
+	}, WithRecipientFilterBus(bus, "msteams"))
+
+	_, err := filter.FilterRecipients(context.Background(), "req-1", []Recipient{{ID: "reviewer1"}})
+	require.NoError(t, err)
+
+	event := drain(t, events, 1)[0]
+	require.Equal(t, EventRecipientResolutionFailed, event.Kind)
+	require.Equal(t, "msteams", event.PluginName)
+	require.Equal(t, "req-1", event.RequestID)
+	require.Equal(t, "reviewer1", event.RecipientID)
+}
+
+func TestAllowlistFilterEmitsRecipientResolutionFailed(t *testing.T) {
+	bus := NewBus()
+	events, unsub := bus.Subscribe(nil)
+	defer unsub()
+
+	filter := NewAllowlistFilter(fakeRoleGetter{"reviewer1": {"auditor"}}, []string{"access-reviewer"},
+		WithRecipientFilterBus(bus, "msteams"))
+
+	_, err := filter.FilterRecipients(context.Background(), "req-1", []Recipient{{ID: "reviewer1"}})
+	require.NoError(t, err)
+
+	event := drain(t, events, 1)[0]
+	require.Equal(t, EventRecipientResolutionFailed, event.Kind)
+	require.Equal(t, "reviewer1", event.RecipientID)
+}
+
+func TestRecipientFilterChainAppliesInOrder(t *testing.T) {
+	chain := RecipientFilterChain{
+		NewPresenceFilter(fakePresenceChecker{"reviewer1": PresenceOutOfOffice}),
+		NewAllowlistFilter(fakeRoleGetter{"reviewer2": {"access-reviewer"}}, []string{"access-reviewer"}),
+	}
+
+	got, err := chain.FilterRecipients(context.Background(), "req-1", []Recipient{
+		{ID: "reviewer1"},
+		{ID: "reviewer2"},
+		{ID: "reviewer3"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Recipient{{ID: "reviewer2"}}, got)
+}
@@ -0,0 +1,341 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package msteams is the base package of the msteams access-request
+// plugin: it wires the callback, common, and msapi packages' inbound
+// callback server, event bus, recipient filters, and msapi client
+// together with the workers pool into the real send/update path those
+// subsystems were each built to be slotted into.
+package msteams
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"sync"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+
+	"github.com/gravitational/teleport/integrations/access/common"
+	"github.com/gravitational/teleport/integrations/access/msteams/callback"
+	"github.com/gravitational/teleport/integrations/access/msteams/msapi"
+	"github.com/gravitational/teleport/integrations/access/msteams/workers"
+)
+
+// TeamsMessage identifies a single Adaptive Card message posted to a
+// recipient, so a later review or expiration event can find it again to
+// post an update.
+type TeamsMessage struct {
+	// ID is the Graph message ID returned when the card was first posted.
+	ID string
+	// RecipientID is the Teams user ID the message was posted to.
+	RecipientID string
+}
+
+// PluginData is the per-request state App stores, recording every
+// message it posted for a request so a later review or expiration can
+// update all of them.
+type PluginData struct {
+	TeamsData []TeamsMessage
+}
+
+// PluginDataStore persists PluginData for a request across App restarts.
+// Teleport's own plugin data API backs the production implementation;
+// tests can use NewMemoryPluginDataStore.
+type PluginDataStore interface {
+	GetPluginData(ctx context.Context, requestID string) (PluginData, error)
+	UpdatePluginData(ctx context.Context, requestID string, data PluginData) error
+}
+
+// MemoryPluginDataStore is a PluginDataStore backed by an in-process map,
+// used by tests and by NewApp when Config.PluginData is left unset.
+type MemoryPluginDataStore struct {
+	mu   sync.Mutex
+	data map[string]PluginData
+}
+
+// NewMemoryPluginDataStore returns an empty, ready-to-use
+// MemoryPluginDataStore.
+func NewMemoryPluginDataStore() *MemoryPluginDataStore {
+	return &MemoryPluginDataStore{data: make(map[string]PluginData)}
+}
+
+// GetPluginData implements PluginDataStore.
+func (s *MemoryPluginDataStore) GetPluginData(_ context.Context, requestID string) (PluginData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[requestID], nil
+}
+
+// UpdatePluginData implements PluginDataStore.
+func (s *MemoryPluginDataStore) UpdatePluginData(_ context.Context, requestID string, data PluginData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[requestID] = data
+	return nil
+}
+
+// ReviewSubmitter is the subset of the Teleport API client the callback
+// server needs: submitting the review a reviewer chose in Teams.
+type ReviewSubmitter = callback.ReviewSubmitter
+
+// MailToTeleportUser maps a Teams user's mail address back to the
+// Teleport user name that should be recorded as a review's author.
+type MailToTeleportUser func(mail string) (teleportUser string, ok bool)
+
+// Config configures an App.
+type Config struct {
+	// MSAPI configures the msapi.Client used to resolve users and
+	// post/update cards.
+	MSAPI msapi.Config
+	// Pool configures the workers.Pool deliveries run through.
+	Pool workers.Config
+	// RecipientFilter is applied to a request's resolved recipients
+	// before any card is posted. May be nil, in which case every
+	// recipient is posted to.
+	RecipientFilter common.RecipientFilter
+	// PluginData persists which messages were posted for a request.
+	// Defaults to an in-process MemoryPluginDataStore if unset.
+	PluginData PluginDataStore
+	// ReviewSubmitter submits the review a reviewer chose in Teams.
+	ReviewSubmitter ReviewSubmitter
+	// MailToTeleportUser maps the Teams user who clicked a button back
+	// to a Teleport user name.
+	MailToTeleportUser MailToTeleportUser
+	// TokenValidator authenticates the Bot Framework JWT carried on
+	// every inbound callback request. Required.
+	TokenValidator callback.TokenValidator
+	// TrustedProxies lists the CIDRs of reverse proxies allowed to set
+	// X-Forwarded-For/X-Real-IP in front of the callback server.
+	TrustedProxies []string
+	Log            *logrus.Entry
+}
+
+// CheckAndSetDefaults validates cfg and fills in defaults for zero
+// fields.
+func (c *Config) CheckAndSetDefaults() error {
+	if c.ReviewSubmitter == nil {
+		return trace.BadParameter("ReviewSubmitter is required")
+	}
+	if c.MailToTeleportUser == nil {
+		return trace.BadParameter("MailToTeleportUser is required")
+	}
+	if c.TokenValidator == nil {
+		return trace.BadParameter("TokenValidator is required")
+	}
+	if c.PluginData == nil {
+		c.PluginData = NewMemoryPluginDataStore()
+	}
+	if c.Log == nil {
+		c.Log = logrus.WithField("plugin", "msteams")
+	}
+	return nil
+}
+
+// App wires recipient filtering, Adaptive Card rendering, msapi, the
+// worker pool, and the inbound callback server together into the
+// msteams plugin's real send/update path.
+type App struct {
+	cfg    Config
+	client *msapi.Client
+	bus    *common.Bus
+	pool   *workers.Pool
+	cb     *callback.Server
+}
+
+// NewApp validates cfg, generates a fresh Action.Submit signing key for
+// this process, and returns a ready-to-use App.
+func NewApp(cfg Config) (*App, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	client, err := msapi.NewClient(cfg.MSAPI)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	signingKey := make([]byte, 32)
+	if _, err := rand.Read(signingKey); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	callback.SetSigningKey(signingKey)
+
+	bus := common.NewBus()
+	pool, err := workers.NewPool(cfg.Pool, bus)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	cb, err := callback.NewServer(callback.Config{
+		TokenValidator: cfg.TokenValidator,
+		UserResolver:   callback.ResolveUserFromTeamsID(client, cfg.MailToTeleportUser),
+		Client:         cfg.ReviewSubmitter,
+		TrustedProxies: cfg.TrustedProxies,
+		Log:            cfg.Log,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &App{cfg: cfg, client: client, bus: bus, pool: pool, cb: cb}, nil
+}
+
+// Events returns a channel of every Event the plugin emits, matching
+// filter (or every event, if filter is nil). See common.Bus.Subscribe.
+func (a *App) Events(filter common.Filter) (events <-chan common.Event, unsubscribe func()) {
+	return a.bus.Subscribe(filter)
+}
+
+// Callback returns the HTTP handler that receives inbound Action.Submit
+// POSTs from Teams.
+func (a *App) Callback() http.Handler {
+	return a.cb
+}
+
+// Close stops accepting new deliveries and waits for queued ones to
+// drain.
+func (a *App) Close() {
+	a.pool.Close()
+}
+
+// HandleRequestEvent resolves req's recipients down to concrete Teams
+// users, runs them through Config.RecipientFilter, and enqueues a new
+// Adaptive Card delivery to each one through the worker pool, recording
+// every successfully posted message in PluginData.
+func (a *App) HandleRequestEvent(ctx context.Context, req RequestInfo, recipientMails []string) error {
+	recipients, err := a.resolveRecipients(ctx, recipientMails)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	if a.cfg.RecipientFilter != nil {
+		recipients, err = a.cfg.RecipientFilter.FilterRecipients(ctx, req.ID, recipients)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+
+	for _, recipient := range recipients {
+		recipient := recipient
+		body, err := renderCard(req, recipient.ID)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+
+		job := workers.Job{
+			RequestID:   req.ID,
+			RecipientID: recipient.ID,
+			Sender: &cardSender{
+				client:      a.client,
+				kind:        sendNew,
+				recipientID: recipient.ID,
+				body:        body,
+				onSent: func(messageID string) {
+					a.recordMessage(ctx, req.ID, TeamsMessage{ID: messageID, RecipientID: recipient.ID})
+					a.bus.Emit(common.Event{
+						Kind:        common.EventMessageSent,
+						PluginName:  "msteams",
+						RequestID:   req.ID,
+						RecipientID: recipient.ID,
+						Payload:     TeamsMessage{ID: messageID, RecipientID: recipient.ID},
+					})
+				},
+			},
+		}
+		if err := a.pool.Enqueue(job); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// HandleReviewEvent re-renders req at its current State and enqueues an
+// update to every message PluginData has on file for req.ID.
+func (a *App) HandleReviewEvent(ctx context.Context, req RequestInfo) error {
+	data, err := a.cfg.PluginData.GetPluginData(ctx, req.ID)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	body, err := renderUpdate(req)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	for _, msg := range data.TeamsData {
+		msg := msg
+		job := workers.Job{
+			RequestID:   req.ID,
+			RecipientID: msg.RecipientID,
+			Sender: &cardSender{
+				client:      a.client,
+				kind:        sendUpdate,
+				recipientID: msg.RecipientID,
+				messageID:   msg.ID,
+				body:        body,
+				onSent: func(string) {
+					a.bus.Emit(common.Event{
+						Kind:        common.EventMessageUpdated,
+						PluginName:  "msteams",
+						RequestID:   req.ID,
+						RecipientID: msg.RecipientID,
+						Payload:     msg,
+					})
+				},
+			},
+		}
+		if err := a.pool.Enqueue(job); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (a *App) resolveRecipients(ctx context.Context, mails []string) ([]common.Recipient, error) {
+	recipients := make([]common.Recipient, 0, len(mails))
+	for _, mail := range mails {
+		user, err := a.client.GetUserByMail(ctx, mail)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				a.bus.Emit(common.Event{
+					Kind:       common.EventRecipientResolutionFailed,
+					PluginName: "msteams",
+					Payload:    mail,
+				})
+				continue
+			}
+			return nil, trace.Wrap(err)
+		}
+		recipients = append(recipients, common.Recipient{ID: user.ID, Name: user.Name})
+	}
+	return recipients, nil
+}
+
+func (a *App) recordMessage(ctx context.Context, requestID string, msg TeamsMessage) {
+	data, err := a.cfg.PluginData.GetPluginData(ctx, requestID)
+	if err != nil {
+		a.cfg.Log.WithError(err).Error("Failed to load plugin data before recording a posted message")
+		return
+	}
+	data.TeamsData = append(data.TeamsData, msg)
+	if err := a.cfg.PluginData.UpdatePluginData(ctx, requestID, data); err != nil {
+		a.cfg.Log.WithError(err).Error("Failed to record a posted message in plugin data")
+	}
+}
@@ -0,0 +1,342 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package msteams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/integrations/access/common"
+	"github.com/gravitational/teleport/integrations/access/msteams/callback"
+	"github.com/gravitational/teleport/integrations/access/msteams/msapi"
+)
+
+// fakeGraphServer is a minimal Azure AD + Graph double letting these
+// tests exercise the real App -> msapi.Client -> HTTP stack end to end,
+// the way the suite's missing FakeTeams would, but self-contained so it
+// doesn't depend on any of the integration-test scaffolding this
+// checkout doesn't have.
+type fakeGraphServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	users     map[string]msapi.User // keyed by mail
+	usersByID map[string]msapi.User
+	posted    []postedCard
+	updated   []postedCard
+}
+
+type postedCard struct {
+	RecipientID string
+	MessageID   string
+	Body        []byte
+}
+
+func newFakeGraphServer() *fakeGraphServer {
+	f := &fakeGraphServer{
+		users:     make(map[string]msapi.User),
+		usersByID: make(map[string]msapi.User),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-id/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "fake-token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		mail := strings.TrimSuffix(strings.TrimPrefix(r.URL.Query().Get("$filter"), "mail eq '"), "'")
+		f.mu.Lock()
+		user, ok := f.users[mail]
+		f.mu.Unlock()
+		var value []msapi.User
+		if ok {
+			value = []msapi.User{user}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"value": value})
+	})
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/users/")
+		switch {
+		case strings.Contains(id, "/chat/messages/"):
+			parts := strings.SplitN(id, "/chat/messages/", 2)
+			f.handleUpdate(w, r, parts[0], parts[1])
+		case strings.HasSuffix(id, "/chat/messages"):
+			f.handlePost(w, r, strings.TrimSuffix(id, "/chat/messages"))
+		default:
+			f.handleGetByID(w, id)
+		}
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeGraphServer) handleGetByID(w http.ResponseWriter, id string) {
+	f.mu.Lock()
+	user, ok := f.usersByID[id]
+	f.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(user)
+}
+
+func (f *fakeGraphServer) handlePost(w http.ResponseWriter, r *http.Request, recipientID string) {
+	body := new(bytes.Buffer)
+	body.ReadFrom(r.Body)
+
+	messageID := uuid.New().String()
+	f.mu.Lock()
+	f.posted = append(f.posted, postedCard{RecipientID: recipientID, MessageID: messageID, Body: body.Bytes()})
+	f.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]string{"id": messageID})
+}
+
+func (f *fakeGraphServer) handleUpdate(w http.ResponseWriter, r *http.Request, recipientID, messageID string) {
+	body := new(bytes.Buffer)
+	body.ReadFrom(r.Body)
+
+	f.mu.Lock()
+	f.updated = append(f.updated, postedCard{RecipientID: recipientID, MessageID: messageID, Body: body.Bytes()})
+	f.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeGraphServer) storeUser(mail, id string) msapi.User {
+	user := msapi.User{ID: id, Name: id, Mail: mail}
+	f.mu.Lock()
+	f.users[mail] = user
+	f.usersByID[id] = user
+	f.mu.Unlock()
+	return user
+}
+
+func (f *fakeGraphServer) waitForPosted(t *testing.T, n int) []postedCard {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		posted := append([]postedCard(nil), f.posted...)
+		f.mu.Unlock()
+		if len(posted) >= n {
+			return posted
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d posted messages", n)
+	return nil
+}
+
+func (f *fakeGraphServer) waitForUpdated(t *testing.T, n int) []postedCard {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		updated := append([]postedCard(nil), f.updated...)
+		f.mu.Unlock()
+		if len(updated) >= n {
+			return updated
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d updated messages", n)
+	return nil
+}
+
+// acceptAllTokenValidator lets these tests exercise the callback server
+// for real without having to mint a Microsoft-signed Bot Framework JWT.
+type acceptAllTokenValidator struct{}
+
+func (acceptAllTokenValidator) Validate(context.Context, string) (*callback.BotFrameworkClaims, error) {
+	return &callback.BotFrameworkClaims{ServiceURL: "https://smba.trafficmanager.net/teams/"}, nil
+}
+
+type fakeReviewSubmitter struct {
+	mu       sync.Mutex
+	requests []types.AccessReview
+	reqIDs   []string
+}
+
+func (f *fakeReviewSubmitter) SubmitAccessRequestReview(ctx context.Context, reqID string, review types.AccessReview) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reqIDs = append(f.reqIDs, reqID)
+	f.requests = append(f.requests, review)
+	return nil
+}
+
+func newTestApp(t *testing.T, graph *fakeGraphServer, opts ...func(*Config)) *App {
+	t.Helper()
+
+	msapiCfg := msapi.Config{AppID: "app-id", AppSecret: "app-secret", TenantID: "tenant-id"}
+	msapiCfg.SetBaseURLs(graph.URL, graph.URL, graph.URL)
+
+	cfg := Config{
+		MSAPI:           msapiCfg,
+		ReviewSubmitter: &fakeReviewSubmitter{},
+		MailToTeleportUser: func(mail string) (string, bool) {
+			return strings.TrimSuffix(mail, "@example.com"), true
+		},
+		TokenValidator: acceptAllTokenValidator{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	app, err := NewApp(cfg)
+	require.NoError(t, err)
+	t.Cleanup(app.Close)
+	return app
+}
+
+func TestHandleRequestEventPostsCardAndEmitsEvent(t *testing.T) {
+	graph := newFakeGraphServer()
+	t.Cleanup(graph.Close)
+	graph.storeUser("alice@example.com", "teams-alice")
+
+	app := newTestApp(t, graph)
+
+	events, unsubscribe := app.Events(common.ForPlugin("msteams"))
+	t.Cleanup(unsubscribe)
+
+	req := RequestInfo{ID: "req-1", Requester: "bob", Roles: []string{"editor"}, Reason: "need access"}
+	require.NoError(t, app.HandleRequestEvent(context.Background(), req, []string{"alice@example.com"}))
+
+	posted := graph.waitForPosted(t, 1)
+	require.Equal(t, "teams-alice", posted[0].RecipientID)
+	require.Contains(t, string(posted[0].Body), "req-1")
+
+	select {
+	case e := <-events:
+		require.Equal(t, common.EventMessageSent, e.Kind)
+		require.Equal(t, "req-1", e.RequestID)
+		require.Equal(t, "teams-alice", e.RecipientID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for EventMessageSent")
+	}
+
+	data, err := app.cfg.PluginData.GetPluginData(context.Background(), "req-1")
+	require.NoError(t, err)
+	require.Len(t, data.TeamsData, 1)
+	require.Equal(t, "teams-alice", data.TeamsData[0].RecipientID)
+}
+
+func TestHandleReviewEventUpdatesEveryPostedMessage(t *testing.T) {
+	graph := newFakeGraphServer()
+	t.Cleanup(graph.Close)
+	graph.storeUser("alice@example.com", "teams-alice")
+
+	app := newTestApp(t, graph)
+
+	req := RequestInfo{ID: "req-2", Requester: "bob", Roles: []string{"editor"}}
+	require.NoError(t, app.HandleRequestEvent(context.Background(), req, []string{"alice@example.com"}))
+	graph.waitForPosted(t, 1)
+
+	req.State = "APPROVED"
+	req.ReviewReason = "looks good"
+	require.NoError(t, app.HandleReviewEvent(context.Background(), req))
+
+	updated := graph.waitForUpdated(t, 1)
+	require.Equal(t, "teams-alice", updated[0].RecipientID)
+	require.Contains(t, string(updated[0].Body), "APPROVED")
+	require.Contains(t, string(updated[0].Body), "looks good")
+}
+
+func TestHandleRequestEventHonorsRecipientFilter(t *testing.T) {
+	graph := newFakeGraphServer()
+	t.Cleanup(graph.Close)
+	graph.storeUser("alice@example.com", "teams-alice")
+	graph.storeUser("carol@example.com", "teams-carol")
+
+	app := newTestApp(t, graph, func(c *Config) {
+		c.RecipientFilter = common.RecipientFilterFunc(func(_ context.Context, _ string, recipients []common.Recipient) ([]common.Recipient, error) {
+			filtered := make([]common.Recipient, 0, len(recipients))
+			for _, r := range recipients {
+				if r.ID != "teams-alice" {
+					filtered = append(filtered, r)
+				}
+			}
+			return filtered, nil
+		})
+	})
+
+	req := RequestInfo{ID: "req-3", Requester: "bob"}
+	require.NoError(t, app.HandleRequestEvent(context.Background(), req, []string{"alice@example.com", "carol@example.com"}))
+
+	posted := graph.waitForPosted(t, 1)
+	require.Len(t, posted, 1)
+	require.Equal(t, "teams-carol", posted[0].RecipientID)
+}
+
+// TestCallbackRoundTripSubmitsReview exercises the inbound half of the
+// round trip: after this same App has posted a card, the test builds the
+// Action.Submit payload that card's Approve button carries, signed via
+// callback.SignAction, and posting it to App.Callback() must result in a
+// SubmitAccessRequestReview call with the resolved Teleport user as
+// author.
+func TestCallbackRoundTripSubmitsReview(t *testing.T) {
+	graph := newFakeGraphServer()
+	t.Cleanup(graph.Close)
+	graph.storeUser("alice@example.com", "teams-alice")
+
+	submitter := &fakeReviewSubmitter{}
+	app := newTestApp(t, graph, func(c *Config) {
+		c.ReviewSubmitter = submitter
+	})
+
+	req := RequestInfo{ID: "req-4", Requester: "bob"}
+	require.NoError(t, app.HandleRequestEvent(context.Background(), req, []string{"alice@example.com"}))
+	graph.waitForPosted(t, 1)
+
+	payload := map[string]string{
+		"requestId":     "req-4",
+		"reviewerId":    "teams-alice",
+		"proposedState": "APPROVED",
+		"reason":        "looks good",
+		"signature":     callback.SignAction("req-4", "teams-alice", "APPROVED"),
+	}
+	raw, err := json.Marshal(payload)
+	require.NoError(t, err)
+
+	httpReq := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(raw))
+	httpReq.Header.Set("Authorization", "Bearer anything")
+	rec := httptest.NewRecorder()
+	app.Callback().ServeHTTP(rec, httpReq)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	submitter.mu.Lock()
+	defer submitter.mu.Unlock()
+	require.Len(t, submitter.reqIDs, 1)
+	require.Equal(t, "req-4", submitter.reqIDs[0])
+	require.Equal(t, types.RequestState_APPROVED, submitter.requests[0].ProposedState)
+	require.Equal(t, "alice", submitter.requests[0].Author)
+	require.Equal(t, "looks good", submitter.requests[0].Reason)
+}
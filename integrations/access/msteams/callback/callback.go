@@ -0,0 +1,218 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package callback implements the inbound side of the msteams plugin's
+// Adaptive Cards: an HTTP server that receives Action.Submit POSTs from
+// reviewers clicking Approve/Deny in Teams and turns them into
+// SubmitAccessRequestReview calls, so reviewers never have to leave
+// Teams to act on a request.
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/sirupsen/logrus"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/integrations/access/msteams/msapi"
+)
+
+// ReviewSubmitter is the subset of the Teleport API client the callback
+// server needs: submitting the review the reviewer chose in Teams.
+type ReviewSubmitter interface {
+	SubmitAccessRequestReview(ctx context.Context, reqID string, review types.AccessReview) error
+}
+
+// Config is the configuration for a callback Server.
+type Config struct {
+	// TokenValidator authenticates the Bot Framework JWT carried on
+	// every inbound request.
+	TokenValidator TokenValidator
+	// UserResolver maps a Teams user ID back to a Teleport user name.
+	UserResolver func(ctx context.Context, teamsUserID string) (teleportUser string, err error)
+	// Client submits the reviewer's decision to Teleport.
+	Client ReviewSubmitter
+	// TrustedProxies lists the CIDRs of reverse proxies allowed to set
+	// X-Forwarded-For/X-Real-IP in front of this server. Requests from
+	// any other address have their headers ignored; only RemoteAddr is
+	// trusted for them.
+	TrustedProxies []string
+	Log            *logrus.Entry
+}
+
+// CheckAndSetDefaults validates the config and fills in defaults.
+func (c *Config) CheckAndSetDefaults() error {
+	if c.TokenValidator == nil {
+		return trace.BadParameter("TokenValidator must be set")
+	}
+	if c.UserResolver == nil {
+		return trace.BadParameter("UserResolver must be set")
+	}
+	if c.Client == nil {
+		return trace.BadParameter("Client must be set")
+	}
+	if c.Log == nil {
+		c.Log = logrus.WithField("plugin", "msteams-callback")
+	}
+	return nil
+}
+
+// Server is an HTTP handler that receives Adaptive Card Action.Submit
+// POSTs from Teams and turns them into access request reviews.
+type Server struct {
+	cfg       Config
+	proxyList trustedProxyList
+}
+
+// NewServer validates cfg and returns a ready-to-use Server.
+func NewServer(cfg Config) (*Server, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	proxyList, err := newTrustedProxyList(cfg.TrustedProxies)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Server{cfg: cfg, proxyList: proxyList}, nil
+}
+
+// actionSubmitPayload is the body of the Action.Submit POST Teams sends
+// when a reviewer clicks an Approve/Deny button. The request ID and
+// reviewer ID are signed into the card's data payload when it is
+// rendered, so the callback can trust the caller's stated intent without
+// re-deriving it from the (unauthenticated) button click alone.
+type actionSubmitPayload struct {
+	// RequestID is the Teleport access request being reviewed.
+	RequestID string `json:"requestId"`
+	// ReviewerTeamsID is the Teams user ID of whoever clicked the
+	// button, echoed back by the Adaptive Card runtime.
+	ReviewerTeamsID string `json:"reviewerId"`
+	// ProposedState is either "APPROVED" or "DENIED".
+	ProposedState string `json:"proposedState"`
+	// Reason is the optional reviewer-supplied comment.
+	Reason string `json:"reason"`
+	// Signature authenticates RequestID+ReviewerTeamsID+ProposedState
+	// against tampering, since Adaptive Card data payloads are
+	// round-tripped through the client unsigned by Teams itself.
+	Signature string `json:"signature"`
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	clientIP := s.proxyList.resolveClientIP(r)
+	log := s.cfg.Log.WithField("client_ip", clientIP)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := s.cfg.TokenValidator.Validate(ctx, bearerToken(r))
+	if err != nil {
+		log.WithError(err).Warn("Rejected msteams callback with invalid Bot Framework token")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload actionSubmitPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "malformed action submit payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := verifyActionSignature(payload); err != nil {
+		log.WithError(err).Warn("Rejected msteams callback with invalid action signature")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if claims.ServiceURL == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	teleportUser, err := s.cfg.UserResolver(ctx, payload.ReviewerTeamsID)
+	if err != nil {
+		log.WithError(err).Error("Failed to resolve Teams user to a Teleport user")
+		http.Error(w, "unknown reviewer", http.StatusForbidden)
+		return
+	}
+
+	state, err := parseProposedState(payload.ProposedState)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.cfg.Client.SubmitAccessRequestReview(ctx, payload.RequestID, types.AccessReview{
+		Author:        teleportUser,
+		ProposedState: state,
+		Created:       time.Now(),
+		Reason:        payload.Reason,
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to submit access request review")
+		http.Error(w, "failed to submit review", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func parseProposedState(s string) (types.RequestState, error) {
+	switch s {
+	case "APPROVED":
+		return types.RequestState_APPROVED, nil
+	case "DENIED":
+		return types.RequestState_DENIED, nil
+	default:
+		return types.RequestState_NONE, trace.BadParameter("unsupported proposed state %q", s)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len(prefix) && auth[:len(prefix)] == prefix {
+		return auth[len(prefix):]
+	}
+	return ""
+}
+
+// ResolveUserFromTeamsID is the default UserResolver, mapping a Teams
+// user ID to a Teleport user name via msapi.GetUserByID and the user's
+// mail address (the same identity key the outbound side uses to resolve
+// recipients).
+func ResolveUserFromTeamsID(client *msapi.Client, mailToTeleportUser func(mail string) (string, bool)) func(ctx context.Context, teamsUserID string) (string, error) {
+	return func(ctx context.Context, teamsUserID string) (string, error) {
+		user, err := client.GetUserByID(ctx, teamsUserID)
+		if err != nil {
+			return "", trace.Wrap(err)
+		}
+		teleportUser, ok := mailToTeleportUser(user.Mail)
+		if !ok {
+			return "", trace.NotFound("no Teleport user mapped to Teams user %q (%s)", teamsUserID, user.Mail)
+		}
+		return teleportUser, nil
+	}
+}
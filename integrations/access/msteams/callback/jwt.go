@@ -0,0 +1,79 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package callback
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gravitational/trace"
+)
+
+// botFrameworkIssuer is the fixed issuer every Bot Framework-signed
+// token carries.
+const botFrameworkIssuer = "https://api.botframework.com"
+
+// BotFrameworkClaims is the subset of a Bot Framework JWT this plugin
+// cares about.
+type BotFrameworkClaims struct {
+	jwt.RegisteredClaims
+	// ServiceURL is the Teams service endpoint the bot should reply to;
+	// Microsoft recommends treating its absence as a sign the token
+	// wasn't actually minted for a bot callback.
+	ServiceURL string `json:"serviceurl"`
+}
+
+// TokenValidator authenticates the Bot Framework JWT carried by an
+// inbound Action.Submit POST.
+type TokenValidator interface {
+	Validate(ctx context.Context, rawToken string) (*BotFrameworkClaims, error)
+}
+
+// JWKSTokenValidator validates Bot Framework tokens against Microsoft's
+// published signing keys, checking the fixed issuer and that the
+// audience matches this bot's app ID.
+type JWKSTokenValidator struct {
+	// AppID is this bot's Microsoft App ID; it must appear as the
+	// token's audience.
+	AppID string
+	// KeyFunc resolves the key used to sign rawToken, normally backed
+	// by a cache of Microsoft's OpenID Connect JWKS document.
+	KeyFunc jwt.Keyfunc
+}
+
+// Validate implements TokenValidator.
+func (v *JWKSTokenValidator) Validate(ctx context.Context, rawToken string) (*BotFrameworkClaims, error) {
+	if rawToken == "" {
+		return nil, trace.AccessDenied("missing bearer token")
+	}
+
+	claims := &BotFrameworkClaims{}
+	token, err := jwt.ParseWithClaims(rawToken, claims, v.KeyFunc,
+		jwt.WithIssuer(botFrameworkIssuer),
+		jwt.WithAudience(v.AppID),
+		jwt.WithValidMethods([]string{"RS256"}),
+	)
+	if err != nil {
+		return nil, trace.Wrap(err, "validating Bot Framework token")
+	}
+	if !token.Valid {
+		return nil, trace.AccessDenied("invalid Bot Framework token")
+	}
+	return claims, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/gravitational/trace"
+)
+
+// signingKey is process-wide because it only has to authenticate
+// round-trips between this plugin's own card renderer and its own
+// callback server; it is generated once at plugin startup and never
+// persisted.
+var signingKey []byte
+
+// SetSigningKey installs the key used to sign and verify Adaptive Card
+// action payloads. It must be called with the same key the card
+// renderer uses to sign the `action.submit` buttons it emits.
+func SetSigningKey(key []byte) {
+	signingKey = key
+}
+
+// SignAction computes the signature for an action.submit payload, so the
+// card renderer can embed it alongside the request/reviewer IDs it puts
+// in the button's data.
+func SignAction(requestID, reviewerTeamsID, proposedState string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(requestID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(reviewerTeamsID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(proposedState))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyActionSignature checks that payload.Signature was produced by
+// SignAction for the same fields, rejecting a submission whose card data
+// was tampered with in transit.
+func verifyActionSignature(payload actionSubmitPayload) error {
+	want := SignAction(payload.RequestID, payload.ReviewerTeamsID, payload.ProposedState)
+	got, err := base64.RawURLEncoding.DecodeString(payload.Signature)
+	if err != nil {
+		return trace.AccessDenied("malformed action signature")
+	}
+	wantRaw, err := base64.RawURLEncoding.DecodeString(want)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if !hmac.Equal(got, wantRaw) {
+		return trace.AccessDenied("action signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package callback
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerifyAction(t *testing.T) {
+	SetSigningKey([]byte("test-signing-key"))
+
+	sig := SignAction("req-1", "teams-user-1", "APPROVED")
+	payload := actionSubmitPayload{
+		RequestID:       "req-1",
+		ReviewerTeamsID: "teams-user-1",
+		ProposedState:   "APPROVED",
+		Signature:       sig,
+	}
+	require.NoError(t, verifyActionSignature(payload))
+
+	tampered := payload
+	tampered.ProposedState = "DENIED"
+	require.Error(t, verifyActionSignature(tampered))
+}
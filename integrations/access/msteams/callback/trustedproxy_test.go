@@ -0,0 +1,81 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package callback
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	proxies, err := newTrustedProxyList([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		remote  string
+		want    string
+	}{
+		{
+			name:    "skips trusted proxies in XFF, returns real client",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.5, 10.0.0.2, 10.0.0.1"},
+			remote:  "10.0.0.1:4000",
+			want:    "203.0.113.5",
+		},
+		{
+			name:    "entire XFF is trusted, falls back to X-Real-IP",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.2, 10.0.0.1", "X-Real-IP": "198.51.100.9"},
+			remote:  "10.0.0.1:4000",
+			want:    "198.51.100.9",
+		},
+		{
+			name:   "no headers at all, falls back to RemoteAddr",
+			remote: "198.51.100.9:4000",
+			want:   "198.51.100.9:4000",
+		},
+		{
+			name:    "untrusted peer's XFF is ignored, returns RemoteAddr",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.5"},
+			remote:  "198.51.100.9:4000",
+			want:    "198.51.100.9:4000",
+		},
+		{
+			name:    "untrusted peer's X-Real-IP is ignored, returns RemoteAddr",
+			headers: map[string]string{"X-Real-IP": "203.0.113.5"},
+			remote:  "198.51.100.9:4000",
+			want:    "198.51.100.9:4000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/callback", nil)
+			require.NoError(t, err)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			req.RemoteAddr = tt.remote
+
+			require.Equal(t, tt.want, proxies.resolveClientIP(req))
+		})
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package msteams
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/teleport/integrations/access/msteams/callback"
+)
+
+// RequestInfo is the subset of a Teleport access request a rendered
+// Adaptive Card needs: enough to describe it to a reviewer and, once
+// reviewed, to show the outcome.
+type RequestInfo struct {
+	// ID is the access request's name.
+	ID string
+	// Requester is the Teleport user who opened the request.
+	Requester string
+	// Roles are the roles being requested.
+	Roles []string
+	// Reason is the requester-supplied justification, if any.
+	Reason string
+	// State is the request's current state: "PENDING", "APPROVED",
+	// "DENIED", or "EXPIRED".
+	State string
+	// ReviewReason is the reviewer-supplied comment that produced State,
+	// if State isn't "PENDING".
+	ReviewReason string
+}
+
+// stateEmoji mirrors the glyph the card shows next to State, matching
+// the ones reviewers already associate with each outcome from the
+// existing Slack/Jira card renderers.
+var stateEmoji = map[string]string{
+	"APPROVED": "✅",
+	"DENIED":   "❌",
+	"EXPIRED":  "⌛",
+}
+
+// renderCard builds the Adaptive Card attachment posted to a recipient
+// when req first needs review. reviewerTeamsID is the Teams user ID of
+// the specific recipient the card is rendered for, since the
+// Action.Submit buttons must carry that reviewer's identity for the
+// callback server to attribute the review correctly.
+func renderCard(req RequestInfo, reviewerTeamsID string) ([]byte, error) {
+	body := []map[string]any{
+		{
+			"type": "TextBlock",
+			"text": fmt.Sprintf("Access Request %s", req.ID),
+			"wrap": true,
+			"size": "Medium",
+		},
+		{
+			"type": "FactSet",
+			"facts": []map[string]string{
+				{"title": "Requester", "value": req.Requester},
+				{"title": "Roles", "value": fmt.Sprint(req.Roles)},
+				{"title": "Reason", "value": req.Reason},
+			},
+		},
+		{
+			"type": "ActionSet",
+			"actions": []map[string]any{
+				submitAction(req.ID, reviewerTeamsID, "APPROVED", "Approve"),
+				submitAction(req.ID, reviewerTeamsID, "DENIED", "Deny"),
+			},
+		},
+	}
+	return marshalAttachment(body)
+}
+
+// renderUpdate builds the Adaptive Card attachment a message is updated
+// to once req is no longer pending, replacing the action buttons with
+// the outcome.
+func renderUpdate(req RequestInfo) ([]byte, error) {
+	body := []map[string]any{
+		{
+			"type": "TextBlock",
+			"text": fmt.Sprintf("Access Request %s", req.ID),
+			"wrap": true,
+			"size": "Medium",
+		},
+		{
+			"type": "TextBlock",
+			"text": fmt.Sprintf("%s %s", stateEmoji[req.State], req.State),
+			"wrap": true,
+		},
+		{
+			"type": "FactSet",
+			"facts": []map[string]string{
+				{"title": "Requester", "value": req.Requester},
+				{"title": "Roles", "value": fmt.Sprint(req.Roles)},
+				{"title": "Reason", "value": req.Reason},
+				{"title": "Review reason", "value": req.ReviewReason},
+			},
+		},
+	}
+	return marshalAttachment(body)
+}
+
+// submitAction builds an Action.Submit button whose data payload is
+// signed via callback.SignAction, so the callback server can trust the
+// request ID, reviewer ID, and proposed state a reviewer's click claims
+// without re-deriving them from the (unauthenticated) button click
+// alone.
+func submitAction(requestID, reviewerTeamsID, proposedState, title string) map[string]any {
+	return map[string]any{
+		"type":  "Action.Submit",
+		"title": title,
+		"data": map[string]string{
+			"requestId":     requestID,
+			"reviewerId":    reviewerTeamsID,
+			"proposedState": proposedState,
+			"signature":     callback.SignAction(requestID, reviewerTeamsID, proposedState),
+		},
+	}
+}
+
+func marshalAttachment(body []map[string]any) ([]byte, error) {
+	attachment := map[string]any{
+		"attachments": []map[string]any{
+			{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+				},
+			},
+		},
+	}
+	raw, err := json.Marshal(attachment)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,83 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package msteams
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gravitational/teleport/integrations/access/msteams/msapi"
+	"github.com/gravitational/teleport/integrations/access/msteams/workers"
+)
+
+// sendKind distinguishes a cardSender posting a brand-new message from
+// one updating a message already on file.
+type sendKind int
+
+const (
+	sendNew sendKind = iota
+	sendUpdate
+)
+
+// cardSender is a workers.Sender that posts or updates an Adaptive Card
+// via msapi, translating msapi's transport-level APIError into the
+// workers.DeliveryError the pool's retry/backoff logic understands. On
+// a successful send it calls onSent with the message's ID (the one
+// msapi.PostCard returned for a new message, or the existing ID for an
+// update), so the caller can record it in PluginData and emit a bus
+// event without the pool itself needing to know about either.
+type cardSender struct {
+	client      *msapi.Client
+	kind        sendKind
+	recipientID string
+	messageID   string
+	body        []byte
+	onSent      func(messageID string)
+}
+
+// Send implements workers.Sender.
+func (s *cardSender) Send(ctx context.Context) error {
+	var (
+		id  string
+		err error
+	)
+	switch s.kind {
+	case sendNew:
+		id, err = s.client.PostCard(ctx, s.recipientID, s.body)
+	case sendUpdate:
+		id = s.messageID
+		err = s.client.UpdateCard(ctx, s.recipientID, s.messageID, s.body)
+	}
+	if err != nil {
+		var apiErr *msapi.APIError
+		if errors.As(err, &apiErr) {
+			return &workers.DeliveryError{
+				StatusCode: apiErr.StatusCode,
+				RetryAfter: apiErr.RetryAfterSeconds,
+				Err:        apiErr,
+			}
+		}
+		return &workers.DeliveryError{Err: err}
+	}
+
+	if s.onSent != nil {
+		s.onSent(id)
+	}
+	return nil
+}
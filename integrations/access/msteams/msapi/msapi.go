@@ -0,0 +1,303 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package msapi is the msteams plugin's client for the two Microsoft
+// APIs it depends on: Azure AD (to mint an app-only access token) and
+// Microsoft Graph (to resolve users and post/update chat messages). It
+// is the base package the callback, workers, and msteams packages were
+// each built to be wired against.
+package msapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	defaultGraphBaseURL = "https://graph.microsoft.com/v1.0"
+	defaultLoginBaseURL = "https://login.microsoftonline.com"
+
+	// tokenExpiryMargin is subtracted from a token's reported lifetime so
+	// a request started just before expiry doesn't race the token
+	// becoming invalid mid-flight.
+	tokenExpiryMargin = 30 * time.Second
+)
+
+// Config configures a Client's access to Azure AD and Microsoft Graph.
+type Config struct {
+	// AppID is this plugin's Azure AD application (client) ID.
+	AppID string
+	// AppSecret is this plugin's Azure AD client secret.
+	AppSecret string
+	// TenantID is the Azure AD tenant the app is registered in.
+	TenantID string
+
+	graphBaseURL string
+	loginBaseURL string
+}
+
+// CheckAndSetDefaults validates c and fills in the production Microsoft
+// endpoints for any base URL a test hasn't already overridden via
+// SetBaseURLs.
+func (c *Config) CheckAndSetDefaults() error {
+	if c.AppID == "" {
+		return trace.BadParameter("AppID is required")
+	}
+	if c.AppSecret == "" {
+		return trace.BadParameter("AppSecret is required")
+	}
+	if c.TenantID == "" {
+		return trace.BadParameter("TenantID is required")
+	}
+	if c.graphBaseURL == "" {
+		c.graphBaseURL = defaultGraphBaseURL
+	}
+	if c.loginBaseURL == "" {
+		c.loginBaseURL = defaultLoginBaseURL
+	}
+	return nil
+}
+
+// SetBaseURLs overrides the Graph and Azure AD login endpoints, so tests
+// can point a Client at a fake server instead of the real Microsoft
+// services. The third argument matches the shape tests use when they
+// also run a fake Bot Framework endpoint, but this Client only ever
+// calls Graph and login, so it's accepted and ignored.
+func (c *Config) SetBaseURLs(graphBaseURL, loginBaseURL, _ string) {
+	c.graphBaseURL = graphBaseURL
+	c.loginBaseURL = loginBaseURL
+}
+
+// User is a Microsoft Graph user, identified by the same mail address
+// Teleport recipients/roles configuration uses to address them.
+type User struct {
+	ID   string `json:"id"`
+	Name string `json:"displayName"`
+	Mail string `json:"mail"`
+}
+
+// APIError reports a non-2xx response from Graph or Azure AD. It carries
+// enough of the response to let a caller decide whether the failure is
+// worth retrying, without msapi itself depending on any particular
+// retry policy.
+type APIError struct {
+	// StatusCode is the HTTP status the API responded with.
+	StatusCode int
+	// RetryAfterSeconds is the server-requested backoff parsed from a
+	// Retry-After response header, or 0 if the response didn't set one.
+	RetryAfterSeconds int64
+	// Body is the raw response body, kept for logging/debugging.
+	Body string
+}
+
+// Error implements error.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("msapi: request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether the failure is the kind of transient
+// throttling/outage (429 Too Many Requests, or any 5xx) worth a
+// backoff-and-retry, as opposed to a permanent client error.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Client calls Azure AD and Microsoft Graph to resolve Teams users and
+// post/update Adaptive Card messages.
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewClient validates cfg and returns a ready-to-use Client.
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Client{cfg: cfg, httpClient: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// GetUserByID returns the Graph user identified by teamsUserID.
+func (c *Client) GetUserByID(ctx context.Context, teamsUserID string) (User, error) {
+	var user User
+	if err := c.graphRequest(ctx, http.MethodGet, fmt.Sprintf("/users/%s", url.PathEscape(teamsUserID)), nil, &user); err != nil {
+		return User{}, trace.Wrap(err)
+	}
+	return user, nil
+}
+
+// GetUserByMail returns the Graph user whose mail address is mail, so
+// the plugin can turn a configured recipient (an email address) into a
+// concrete Teams user ID to post to.
+func (c *Client) GetUserByMail(ctx context.Context, mail string) (User, error) {
+	var result struct {
+		Value []User `json:"value"`
+	}
+	filter := fmt.Sprintf("mail eq '%s'", mail)
+	path := fmt.Sprintf("/users?$filter=%s", url.QueryEscape(filter))
+	if err := c.graphRequest(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return User{}, trace.Wrap(err)
+	}
+	if len(result.Value) == 0 {
+		return User{}, trace.NotFound("no Teams user found for mail %q", mail)
+	}
+	return result.Value[0], nil
+}
+
+// PostCard posts body, a serialized Adaptive Card attachment, as a new
+// chat message to recipientID, returning the new message's ID.
+func (c *Client) PostCard(ctx context.Context, recipientID string, body []byte) (string, error) {
+	var result struct {
+		ID string `json:"id"`
+	}
+	path := fmt.Sprintf("/users/%s/chat/messages", url.PathEscape(recipientID))
+	if err := c.graphRequest(ctx, http.MethodPost, path, body, &result); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return result.ID, nil
+}
+
+// UpdateCard replaces the content of a message previously returned by
+// PostCard.
+func (c *Client) UpdateCard(ctx context.Context, recipientID, messageID string, body []byte) error {
+	path := fmt.Sprintf("/users/%s/chat/messages/%s", url.PathEscape(recipientID), url.PathEscape(messageID))
+	return trace.Wrap(c.graphRequest(ctx, http.MethodPatch, path, body, nil))
+}
+
+// graphRequest performs an authenticated call against the Graph API,
+// decoding a JSON response body into out (if non-nil and the response
+// isn't empty).
+func (c *Client) graphRequest(ctx context.Context, method, path string, body []byte, out any) error {
+	token, err := c.ensureToken(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.cfg.graphBaseURL+path, reqBody)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return &APIError{
+			StatusCode:        resp.StatusCode,
+			RetryAfterSeconds: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Body:              string(respBody),
+		}
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	return trace.Wrap(json.Unmarshal(respBody, out))
+}
+
+// ensureToken returns a cached app-only access token, fetching a new one
+// from Azure AD if none is cached or the cached one is near expiry.
+func (c *Client) ensureToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"client_id":     {c.cfg.AppID},
+		"client_secret": {c.cfg.AppSecret},
+		"scope":         {"https://graph.microsoft.com/.default"},
+		"grant_type":    {"client_credentials"},
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", c.cfg.loginBaseURL, c.cfg.TenantID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, bytes.NewReader([]byte(form.Encode())))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", trace.Wrap(err)
+	}
+
+	c.token = result.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
+}
+
+// parseRetryAfter parses a Retry-After header's value in seconds,
+// returning 0 if it's absent or not a plain integer (Retry-After can
+// also be an HTTP-date, which Graph/Azure AD don't send in practice).
+func parseRetryAfter(header string) int64 {
+	if header == "" {
+		return 0
+	}
+	seconds, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seconds
+}
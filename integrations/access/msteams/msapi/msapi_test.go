@@ -0,0 +1,112 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package msapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient starts a fake Azure AD + Graph server and returns a
+// Client pointed at it, so tests exercise the real token-acquisition and
+// request-signing path without calling out to Microsoft.
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	cfg := Config{AppID: "app-id", AppSecret: "app-secret", TenantID: "tenant-id"}
+	cfg.SetBaseURLs(srv.URL, srv.URL, srv.URL)
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+	return client
+}
+
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"access_token": "fake-token", "expires_in": 3600})
+}
+
+func TestClientPostAndUpdateCardRoundTrip(t *testing.T) {
+	var lastAuth string
+	var posted, updated []byte
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-id/oauth2/v2.0/token", tokenHandler)
+	mux.HandleFunc("/users/teams-user-1/chat/messages", func(w http.ResponseWriter, r *http.Request) {
+		lastAuth = r.Header.Get("Authorization")
+		posted, _ = io.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(map[string]string{"id": "msg-1"})
+	})
+	mux.HandleFunc("/users/teams-user-1/chat/messages/msg-1", func(w http.ResponseWriter, r *http.Request) {
+		updated, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	client := newTestClient(t, mux)
+
+	id, err := client.PostCard(context.Background(), "teams-user-1", []byte(`{"v":1}`))
+	require.NoError(t, err)
+	require.Equal(t, "msg-1", id)
+	require.Equal(t, "Bearer fake-token", lastAuth)
+	require.Equal(t, `{"v":1}`, string(posted))
+
+	err = client.UpdateCard(context.Background(), "teams-user-1", "msg-1", []byte(`{"v":2}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"v":2}`, string(updated))
+}
+
+func TestClientGetUserByMailNotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-id/oauth2/v2.0/token", tokenHandler)
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"value": []User{}})
+	})
+
+	client := newTestClient(t, mux)
+
+	_, err := client.GetUserByMail(context.Background(), "nobody@example.com")
+	require.True(t, trace.IsNotFound(err))
+}
+
+func TestClientSurfacesRetryAfterOnThrottling(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-id/oauth2/v2.0/token", tokenHandler)
+	mux.HandleFunc("/users/teams-user-1/chat/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	client := newTestClient(t, mux)
+
+	_, err := client.PostCard(context.Background(), "teams-user-1", []byte(`{}`))
+	require.Error(t, err)
+
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	require.Equal(t, int64(7), apiErr.RetryAfterSeconds)
+	require.True(t, apiErr.Retryable())
+}
@@ -20,6 +20,8 @@ package testlib
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"runtime"
 	"strings"
 	"sync"
@@ -149,6 +151,37 @@ func (s *MsTeamsSuite) TestRecipientsConfig() {
 	require.Equal(t, msgs[1].RecipientID, s.reviewer2TeamsUser.ID)
 }
 
+// SetFilter installs filter as the plugin's recipient filter, letting
+// individual tests verify filtering/rewriting behavior without having to
+// rebuild the whole app config.
+func (s *MsTeamsSuite) SetFilter(filter common.RecipientFilter) {
+	s.appConfig.RecipientFilter = filter
+}
+
+func (s *MsTeamsSuite) TestRecipientsConfigWithPresenceFilter() {
+	t := s.T()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	t.Cleanup(cancel)
+
+	s.appConfig.Recipients = common.RawRecipientsMap{
+		types.Wildcard: []string{s.reviewer1TeamsUser.ID, s.reviewer2TeamsUser.ID},
+	}
+	s.SetFilter(common.NewPresenceFilter(s.fakeTeams))
+	s.fakeTeams.SetUserPresence(s.reviewer1TeamsUser.ID, common.PresenceOutOfOffice)
+
+	s.startApp()
+
+	request := s.CreateAccessRequest(ctx, integration.RequesterOSSUserName, nil)
+	pluginData := s.checkPluginData(ctx, request.GetName(), func(data msteams.PluginData) bool {
+		return len(data.TeamsData) > 0
+	})
+	require.Len(t, pluginData.TeamsData, 1)
+
+	msgs, err := s.getNewMessages(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, s.reviewer2TeamsUser.ID, msgs[0].RecipientID)
+}
+
 func (s *MsTeamsSuite) TestApproval() {
 	t := s.T()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
@@ -413,12 +446,18 @@ func (s *MsTeamsSuite) TestRace() {
 
 	s.startApp()
 
+	// Make the first reviewer's messages bounce off a transient 429 once
+	// before succeeding, to exercise the worker pool's backoff/retry path
+	// alongside the rest of the race.
+	s.fakeTeams.SetResponse(s.reviewer1TeamsUser.ID, http.StatusTooManyRequests, time.Second)
+
 	var (
 		raceErr           error
 		raceErrOnce       sync.Once
 		msgIDs            sync.Map
 		msgsCount         int32
 		msgUpdateCounters sync.Map
+		msgUpdatesCount   int32
 	)
 	setRaceErr := func(err error) error {
 		raceErrOnce.Do(func() {
@@ -495,12 +534,25 @@ func (s *MsTeamsSuite) TestRace() {
 			val, _ := msgUpdateCounters.LoadOrStore(threadMsgKey, &newCounter)
 			counterPtr := val.(*int32)
 			atomic.AddInt32(counterPtr, 1)
+			atomic.AddInt32(&msgUpdatesCount, 1)
 
 			return nil
 		})
 	}
 
-	time.Sleep(1 * time.Second)
+	// Wait for every message and every one of its expected updates to
+	// actually arrive, instead of sleeping a fixed duration and checking
+	// whatever happened to drain by then - a slow run would otherwise
+	// silently pass with some updates still in flight.
+	err = WaitFor(ctx, func() bool {
+		return atomic.LoadInt32(&msgsCount) == int32(2*s.raceNumber) &&
+			atomic.LoadInt32(&msgUpdatesCount) == int32(4*s.raceNumber)
+	}, WithFailureMessage(func() string {
+		return fmt.Sprintf("got %d/%d messages and %d/%d updates",
+			atomic.LoadInt32(&msgsCount), 2*s.raceNumber,
+			atomic.LoadInt32(&msgUpdatesCount), 4*s.raceNumber)
+	}))
+	require.NoError(t, err)
 
 	process.Terminate()
 	<-process.Done()
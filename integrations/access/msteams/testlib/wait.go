@@ -0,0 +1,94 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package testlib
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	defaultWaitForPollInterval = 50 * time.Millisecond
+	defaultWaitForTimeout      = 5 * time.Second
+)
+
+// WaitForOption customizes WaitFor's polling behavior.
+type WaitForOption func(*waitForConfig)
+
+type waitForConfig struct {
+	pollInterval   time.Duration
+	timeout        time.Duration
+	failureMessage func() string
+}
+
+// WithPollInterval overrides how often WaitFor re-checks condition.
+func WithPollInterval(d time.Duration) WaitForOption {
+	return func(c *waitForConfig) { c.pollInterval = d }
+}
+
+// WithTimeout overrides how long WaitFor waits before giving up.
+func WithTimeout(d time.Duration) WaitForOption {
+	return func(c *waitForConfig) { c.timeout = d }
+}
+
+// WithFailureMessage attaches a callback producing extra diagnostic
+// state (e.g. queued messages, update counters) to include in the error
+// if condition is never satisfied.
+func WithFailureMessage(f func() string) WaitForOption {
+	return func(c *waitForConfig) { c.failureMessage = f }
+}
+
+// WaitFor blocks until condition returns true, ctx is done, or the
+// configured timeout (5s by default) elapses, polling every
+// pollInterval (50ms by default). It replaces ad-hoc time.Sleep calls
+// and channel-draining loops in tests with a single primitive that
+// fails fast with a descriptive message instead of either racing a
+// fixed sleep or hanging forever.
+func WaitFor(ctx context.Context, condition func() bool, opts ...WaitForOption) error {
+	cfg := waitForConfig{
+		pollInterval: defaultWaitForPollInterval,
+		timeout:      defaultWaitForTimeout,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(cfg.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if condition() {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if cfg.failureMessage != nil {
+				return trace.Errorf("condition was not satisfied within %s: %s", cfg.timeout, cfg.failureMessage())
+			}
+			return trace.Errorf("condition was not satisfied within %s", cfg.timeout)
+		}
+	}
+}
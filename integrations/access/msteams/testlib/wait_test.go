@@ -0,0 +1,55 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package testlib
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForReturnsAssoonAsConditionIsTrue(t *testing.T) {
+	var calls int32
+	err := WaitFor(context.Background(), func() bool {
+		return atomic.AddInt32(&calls, 1) >= 3
+	}, WithPollInterval(time.Millisecond), WithTimeout(time.Second))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, atomic.LoadInt32(&calls), int32(3))
+}
+
+func TestWaitForTimesOutWithFailureMessage(t *testing.T) {
+	err := WaitFor(context.Background(), func() bool { return false },
+		WithPollInterval(time.Millisecond),
+		WithTimeout(20*time.Millisecond),
+		WithFailureMessage(func() string { return "queued=0" }),
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "queued=0")
+}
+
+func TestWaitForRespectsParentContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := WaitFor(ctx, func() bool { return false }, WithTimeout(time.Second))
+	require.Error(t, err)
+}
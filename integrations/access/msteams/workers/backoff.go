@@ -0,0 +1,44 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package workers
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoffDelay returns how long to wait before retrying attempt (1-based)
+// failed deliveries, honoring a server-requested retryAfter when one was
+// given and otherwise using full-jitter exponential backoff: a random
+// delay between zero and base doubled per attempt, capped at max.
+func backoffDelay(attempt int, base, max time.Duration, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > max {
+			return max
+		}
+		return retryAfter
+	}
+
+	delay := base << uint(attempt-1)
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
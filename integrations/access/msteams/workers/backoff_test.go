@@ -0,0 +1,47 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoffDelayHonorsRetryAfter(t *testing.T) {
+	delay := backoffDelay(1, time.Second, 30*time.Second, 5*time.Second)
+	require.Equal(t, 5*time.Second, delay)
+}
+
+func TestBackoffDelayCapsRetryAfterAtMax(t *testing.T) {
+	delay := backoffDelay(1, time.Second, 10*time.Second, time.Minute)
+	require.Equal(t, 10*time.Second, delay)
+}
+
+func TestBackoffDelayIsBoundedAndJittered(t *testing.T) {
+	const base = 100 * time.Millisecond
+	const max = 5 * time.Second
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := backoffDelay(attempt, base, max, 0)
+		require.GreaterOrEqual(t, delay, time.Duration(0))
+		require.LessOrEqual(t, delay, max)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package workers implements a bounded, per-recipient delivery pool for
+// the msteams plugin. It decouples posting/updating Adaptive Card
+// messages (which can 429 or 5xx under Graph API throttling) from the
+// event-handling goroutine, so a single slow or rate-limited recipient
+// cannot head-of-line block deliveries to everyone else.
+package workers
+
+import (
+	"context"
+	"net/http"
+)
+
+// Sender performs a single delivery attempt, e.g. posting or updating a
+// Teams message via msapi. It is implemented by the msteams plugin and
+// handed to the pool as part of a Job.
+type Sender interface {
+	Send(ctx context.Context) error
+}
+
+// SenderFunc adapts a plain function to a Sender.
+type SenderFunc func(ctx context.Context) error
+
+// Send implements Sender.
+func (f SenderFunc) Send(ctx context.Context) error { return f(ctx) }
+
+// DeliveryError reports the outcome of a failed Sender.Send call,
+// distinguishing transient Graph API throttling/outages - which are
+// worth retrying - from permanent failures that should go straight to
+// the dead-letter sink.
+type DeliveryError struct {
+	// StatusCode is the HTTP status Graph API responded with, or 0 if
+	// the failure never reached the wire (e.g. a context deadline).
+	StatusCode int
+	// RetryAfter is the server-requested backoff, parsed from a
+	// Retry-After response header. Zero if the response didn't set one.
+	RetryAfter int64 // seconds; kept as a primitive so DeliveryError stays comparable in tests
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *DeliveryError) Error() string {
+	if e.Err == nil {
+		return "delivery failed"
+	}
+	return e.Err.Error()
+}
+
+// Unwrap lets errors.Is/As see through to Err.
+func (e *DeliveryError) Unwrap() error { return e.Err }
+
+// Retryable reports whether the failure is the kind of transient Graph
+// API hiccup (429 Too Many Requests, or any 5xx) worth a backoff-and-
+// retry, as opposed to a permanent client error.
+func (e *DeliveryError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
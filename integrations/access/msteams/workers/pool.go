@@ -0,0 +1,257 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/jonboulle/clockwork"
+
+	"github.com/gravitational/teleport/integrations/access/common"
+)
+
+const (
+	// DefaultConcurrency bounds the number of deliveries the pool runs
+	// at once, across all recipients.
+	DefaultConcurrency = 8
+	// DefaultQueueSize bounds how many pending jobs a single recipient
+	// may have queued before Enqueue starts rejecting new ones.
+	DefaultQueueSize = 32
+	// DefaultMaxAttempts is how many times a job is tried, including the
+	// first attempt, before it is handed to the dead-letter sink.
+	DefaultMaxAttempts = 5
+	// DefaultBaseBackoff is the starting delay for exponential backoff.
+	DefaultBaseBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff caps both exponential backoff and any
+	// server-requested Retry-After delay.
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Config controls Pool's concurrency and retry behavior.
+type Config struct {
+	// Concurrency bounds how many deliveries run at once, across all
+	// recipients.
+	Concurrency int
+	// QueueSize bounds how many pending jobs a single recipient may have
+	// queued before Enqueue starts rejecting new ones.
+	QueueSize int
+	// MaxAttempts is how many times a job is tried, including the first
+	// attempt, before it is handed to the dead-letter sink.
+	MaxAttempts int
+	// BaseBackoff is the starting delay for exponential backoff.
+	BaseBackoff time.Duration
+	// MaxBackoff caps both exponential backoff and any server-requested
+	// Retry-After delay.
+	MaxBackoff time.Duration
+	// Clock is used for sleeping between retries; defaults to the real
+	// clock. Tests can inject a clockwork.FakeClock to avoid real waits.
+	Clock clockwork.Clock
+}
+
+// CheckAndSetDefaults validates c and fills in defaults for zero fields.
+func (c *Config) CheckAndSetDefaults() error {
+	if c.Concurrency == 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+	if c.Concurrency < 0 {
+		return trace.BadParameter("Concurrency must be positive")
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = DefaultQueueSize
+	}
+	if c.QueueSize < 0 {
+		return trace.BadParameter("QueueSize must be positive")
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.MaxAttempts < 0 {
+		return trace.BadParameter("MaxAttempts must be positive")
+	}
+	if c.BaseBackoff == 0 {
+		c.BaseBackoff = DefaultBaseBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.Clock == nil {
+		c.Clock = clockwork.NewRealClock()
+	}
+	return nil
+}
+
+// Job is a single message delivery to retry and, if it keeps failing,
+// dead-letter.
+type Job struct {
+	// RequestID is the Teleport access request the delivery concerns.
+	RequestID string
+	// RecipientID is the chat-backend identity being delivered to. All
+	// jobs sharing a RecipientID are delivered strictly in order, so a
+	// retry never races an update for the same message.
+	RecipientID string
+	// Sender performs the actual delivery attempt.
+	Sender Sender
+}
+
+// DeadLetter describes a Job the pool gave up on: it either exhausted
+// Config.MaxAttempts or failed with a non-retryable error.
+type DeadLetter struct {
+	Job      Job
+	Attempts int
+	Err      error
+}
+
+// Pool delivers Jobs through one bounded, ordered queue per recipient,
+// with a shared concurrency limit across all recipients. A job that
+// fails with a retryable DeliveryError is retried with backoff; one that
+// exhausts its attempts is emitted on the event bus as
+// common.EventDeliveryFailed instead of being silently dropped.
+type Pool struct {
+	cfg Config
+	bus *common.Bus
+
+	sem chan struct{}
+
+	mu      sync.Mutex
+	queues  map[string]chan Job
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewPool returns a ready-to-use Pool. bus may be nil, in which case
+// dead-lettered jobs are simply dropped.
+func NewPool(cfg Config, bus *common.Bus) (*Pool, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Pool{
+		cfg:     cfg,
+		bus:     bus,
+		sem:     make(chan struct{}, cfg.Concurrency),
+		queues:  make(map[string]chan Job),
+		closing: make(chan struct{}),
+	}, nil
+}
+
+// Enqueue queues job for delivery, starting a worker goroutine for its
+// recipient if one isn't already running. It returns trace.LimitExceeded
+// if that recipient's queue is already full, so a caller can surface
+// backpressure instead of blocking the event-handling goroutine.
+func (p *Pool) Enqueue(job Job) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.closing:
+		return trace.Errorf("delivery pool is closed")
+	default:
+	}
+
+	queue, ok := p.queues[job.RecipientID]
+	if !ok {
+		queue = make(chan Job, p.cfg.QueueSize)
+		p.queues[job.RecipientID] = queue
+		p.wg.Add(1)
+		go p.runQueue(queue)
+	}
+
+	select {
+	case queue <- job:
+		return nil
+	default:
+		return trace.LimitExceeded("delivery queue for recipient %q is full", job.RecipientID)
+	}
+}
+
+// Close stops accepting new jobs and waits for all queued ones to drain.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	select {
+	case <-p.closing:
+		p.mu.Unlock()
+		return
+	default:
+		close(p.closing)
+	}
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *Pool) runQueue(queue chan Job) {
+	defer p.wg.Done()
+	for job := range queue {
+		p.deliver(job)
+	}
+}
+
+// deliver runs job through up to Config.MaxAttempts delivery attempts,
+// backing off between them. The concurrency semaphore is only held for
+// the duration of each attempt's Send call, acquired fresh in send - not
+// across the backoff sleep between attempts - so a recipient waiting out
+// a long Retry-After can't hold a pool-wide delivery slot idle and
+// head-of-line block every other recipient's deliveries.
+func (p *Pool) deliver(job Job) {
+	var lastErr error
+	attempts := 0
+	for attempts < p.cfg.MaxAttempts {
+		attempts++
+		lastErr = p.send(job)
+		if lastErr == nil {
+			return
+		}
+
+		var deliveryErr *DeliveryError
+		retryAfter := time.Duration(0)
+		retryable := true
+		if errors.As(lastErr, &deliveryErr) {
+			retryable = deliveryErr.Retryable()
+			retryAfter = time.Duration(deliveryErr.RetryAfter) * time.Second
+		}
+		if !retryable || attempts == p.cfg.MaxAttempts {
+			break
+		}
+
+		p.cfg.Clock.Sleep(backoffDelay(attempts, p.cfg.BaseBackoff, p.cfg.MaxBackoff, retryAfter))
+	}
+
+	if p.bus != nil {
+		p.bus.Emit(common.Event{
+			Kind:        common.EventDeliveryFailed,
+			RequestID:   job.RequestID,
+			RecipientID: job.RecipientID,
+			Payload:     DeadLetter{Job: job, Attempts: attempts, Err: lastErr},
+		})
+	}
+}
+
+// send runs a single delivery attempt, holding the pool-wide concurrency
+// semaphore only for the attempt itself.
+func (p *Pool) send(job Job) error {
+	p.sem <- struct{}{}
+	defer func() { <-p.sem }()
+	return job.Sender.Send(context.Background())
+}
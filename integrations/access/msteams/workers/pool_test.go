@@ -0,0 +1,221 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package workers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gravitational/teleport/integrations/access/common"
+)
+
+func TestPoolRetriesTransientFailuresThenSucceeds(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	pool, err := NewPool(Config{MaxAttempts: 3, Clock: clock}, nil)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	var attempts int32
+	done := make(chan struct{})
+	sender := SenderFunc(func(ctx context.Context) error {
+		n := atomic.AddInt32(&attempts, 1)
+		if n < 3 {
+			return &DeliveryError{StatusCode: http.StatusTooManyRequests, Err: context.DeadlineExceeded}
+		}
+		close(done)
+		return nil
+	})
+
+	require.NoError(t, pool.Enqueue(Job{RecipientID: "r1", Sender: sender}))
+
+	advancing := make(chan struct{})
+	go func() {
+		defer close(advancing)
+		for i := 0; i < 2; i++ {
+			clock.BlockUntil(1)
+			clock.Advance(time.Minute)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for delivery to eventually succeed")
+	}
+	<-advancing
+
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestPoolEmitsDeadLetterAfterExhaustingAttempts(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	bus := common.NewBus()
+	events, unsubscribe := bus.Subscribe(nil)
+	t.Cleanup(unsubscribe)
+
+	pool, err := NewPool(Config{MaxAttempts: 2, Clock: clock}, bus)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	sender := SenderFunc(func(ctx context.Context) error {
+		return &DeliveryError{StatusCode: http.StatusTooManyRequests, Err: context.DeadlineExceeded}
+	})
+	require.NoError(t, pool.Enqueue(Job{RequestID: "req-1", RecipientID: "r1", Sender: sender}))
+
+	go func() {
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+	}()
+
+	select {
+	case e := <-events:
+		require.Equal(t, common.EventDeliveryFailed, e.Kind)
+		require.Equal(t, "req-1", e.RequestID)
+		dead, ok := e.Payload.(DeadLetter)
+		require.True(t, ok)
+		require.Equal(t, 2, dead.Attempts)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for dead-letter event")
+	}
+}
+
+func TestPoolDoesNotRetryPermanentFailures(t *testing.T) {
+	bus := common.NewBus()
+	events, unsubscribe := bus.Subscribe(nil)
+	t.Cleanup(unsubscribe)
+
+	pool, err := NewPool(Config{MaxAttempts: 5}, bus)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	var attempts int32
+	sender := SenderFunc(func(ctx context.Context) error {
+		atomic.AddInt32(&attempts, 1)
+		return &DeliveryError{StatusCode: http.StatusBadRequest, Err: context.Canceled}
+	})
+	require.NoError(t, pool.Enqueue(Job{RequestID: "req-1", RecipientID: "r1", Sender: sender}))
+
+	select {
+	case e := <-events:
+		require.Equal(t, common.EventDeliveryFailed, e.Kind)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for dead-letter event")
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestPoolDoesNotRetryPermanentFailuresReportsRealAttemptCount(t *testing.T) {
+	bus := common.NewBus()
+	events, unsubscribe := bus.Subscribe(nil)
+	t.Cleanup(unsubscribe)
+
+	pool, err := NewPool(Config{MaxAttempts: 5}, bus)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	sender := SenderFunc(func(ctx context.Context) error {
+		return &DeliveryError{StatusCode: http.StatusBadRequest, Err: context.Canceled}
+	})
+	require.NoError(t, pool.Enqueue(Job{RequestID: "req-1", RecipientID: "r1", Sender: sender}))
+
+	select {
+	case e := <-events:
+		dead, ok := e.Payload.(DeadLetter)
+		require.True(t, ok)
+		require.Equal(t, 1, dead.Attempts, "a permanent failure should dead-letter after its one attempt, not MaxAttempts")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for dead-letter event")
+	}
+}
+
+func TestPoolDoesNotHoldConcurrencySlotDuringBackoff(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	pool, err := NewPool(Config{Concurrency: 1, MaxAttempts: 2, Clock: clock}, nil)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	blockedDone := make(chan struct{})
+	var blockedAttempts int32
+	blockedSender := SenderFunc(func(ctx context.Context) error {
+		if atomic.AddInt32(&blockedAttempts, 1) == 1 {
+			return &DeliveryError{StatusCode: http.StatusTooManyRequests, Err: context.DeadlineExceeded}
+		}
+		close(blockedDone)
+		return nil
+	})
+
+	otherStarted := make(chan struct{})
+	otherSender := SenderFunc(func(ctx context.Context) error {
+		close(otherStarted)
+		return nil
+	})
+
+	require.NoError(t, pool.Enqueue(Job{RecipientID: "r1", Sender: blockedSender}))
+
+	// Wait for r1's first attempt to fail and enter its backoff sleep
+	// before enqueueing r2 - if the semaphore were held across the sleep,
+	// r2 (with Concurrency: 1) could never start until r1's sleep ends.
+	clock.BlockUntil(1)
+	require.NoError(t, pool.Enqueue(Job{RecipientID: "r2", Sender: otherSender}))
+
+	select {
+	case <-otherStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("r2's delivery never started while r1 was sleeping between retries")
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case <-blockedDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for r1's retry to run")
+	}
+}
+
+func TestPoolRejectsJobsWhenRecipientQueueIsFull(t *testing.T) {
+	started := make(chan struct{}, 1)
+	blocking := make(chan struct{})
+	pool, err := NewPool(Config{QueueSize: 1, MaxAttempts: 1}, nil)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	blockingSender := SenderFunc(func(ctx context.Context) error {
+		started <- struct{}{}
+		<-blocking
+		return nil
+	})
+	noopSender := SenderFunc(func(ctx context.Context) error { return nil })
+
+	// This job is picked up by the queue's worker goroutine and blocks it,
+	// so the following jobs queue up behind it instead of racing it.
+	require.NoError(t, pool.Enqueue(Job{RecipientID: "r1", Sender: blockingSender}))
+	<-started
+
+	require.NoError(t, pool.Enqueue(Job{RecipientID: "r1", Sender: noopSender}))
+	err = pool.Enqueue(Job{RecipientID: "r1", Sender: noopSender})
+	require.Error(t, err)
+
+	close(blocking)
+}
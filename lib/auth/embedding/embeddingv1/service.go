@@ -0,0 +1,95 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package embeddingv1 is the auth-server-side handler for the
+// teleport.embedding.v1 gRPC service: it authorizes each call and then
+// delegates to a services.Embeddings implementation, the same way
+// presencev1 sits in front of the presence service.
+package embeddingv1
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+
+	embeddingpb "github.com/gravitational/teleport/api/gen/proto/go/teleport/embedding/v1"
+	"github.com/gravitational/teleport/lib/services"
+)
+
+// Checker authorizes a caller's access to a kind of embedding. It's
+// narrower than the auth server's general RBAC checker so this package
+// stays testable without pulling in the full authorizer.
+type Checker interface {
+	// CheckAccess returns an error unless the caller may perform verb
+	// (e.g. types.VerbRead, types.VerbUpdate) against embeddings of kind.
+	CheckAccess(ctx context.Context, kind, verb string) error
+}
+
+const (
+	verbRead   = "read"
+	verbUpdate = "update"
+)
+
+// Service implements the server side of the teleport.embedding.v1 RPCs,
+// gating each one with a Checker before it reaches the backend.
+type Service struct {
+	embeddings services.Embeddings
+	checker    Checker
+}
+
+// NewService returns a Service backed by embeddings, gating every RPC
+// through checker.
+func NewService(embeddings services.Embeddings, checker Checker) (*Service, error) {
+	if embeddings == nil {
+		return nil, trace.BadParameter("embeddings service is required")
+	}
+	if checker == nil {
+		return nil, trace.BadParameter("checker is required")
+	}
+	return &Service{embeddings: embeddings, checker: checker}, nil
+}
+
+// QuerySimilar authorizes and serves a QuerySimilar RPC.
+func (s *Service) QuerySimilar(ctx context.Context, kind string, query []float32, k int, filter *services.QueryFilter) ([]services.ScoredEmbedding, error) {
+	if err := s.checker.CheckAccess(ctx, kind, verbRead); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	results, err := s.embeddings.QuerySimilar(ctx, kind, query, k, filter)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return results, nil
+}
+
+// UpsertEmbeddings authorizes and serves an UpsertEmbeddings RPC. Every
+// kind present in batch must be authorized, since a single call can
+// backfill embeddings for more than one kind at once.
+func (s *Service) UpsertEmbeddings(ctx context.Context, batch []*embeddingpb.Embedding) error {
+	checked := make(map[string]bool, len(batch))
+	for _, embedding := range batch {
+		kind := embedding.GetEmbeddedKind()
+		if checked[kind] {
+			continue
+		}
+		if err := s.checker.CheckAccess(ctx, kind, verbUpdate); err != nil {
+			return trace.Wrap(err)
+		}
+		checked[kind] = true
+	}
+	return trace.Wrap(s.embeddings.UpsertEmbeddings(ctx, batch))
+}
@@ -0,0 +1,350 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package presencev1
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricNamespace = "teleport"
+
+	// DefaultBurstSize is the token-bucket capacity used when a
+	// RateLimitConfig doesn't specify one.
+	DefaultBurstSize = 50
+	// DefaultRefillPerSecond is the steady-state refill rate used when a
+	// RateLimitConfig doesn't specify one.
+	DefaultRefillPerSecond = 10
+
+	// emaHalfLife is the half-life used to weight the sampled request
+	// rate's exponential moving average: a sample this long ago
+	// contributes half as much to the current estimate as one taken now.
+	emaHalfLife = 10 * time.Second
+
+	// idleMonitorTTL is how long an identity's bucket must sit full and
+	// untouched before its monitor is evicted. Below this, a long-lived
+	// auth server accumulates one identityMonitor per distinct caller
+	// forever.
+	idleMonitorTTL = 15 * time.Minute
+	// monitorGCInterval bounds how often monitorFor sweeps for idle
+	// monitors to evict, so the sweep isn't repeated on every call.
+	monitorGCInterval = time.Minute
+)
+
+// RateLimitConfig configures the per-identity token-bucket rate limiter
+// shared by presencev1's RPCs. A RateLimiter reads it once, in
+// NewRateLimiter; changing the limits takes a new RateLimiter.
+type RateLimitConfig struct {
+	// BurstSize is the token-bucket capacity, i.e. how many requests an
+	// identity can make back-to-back before being throttled down to
+	// RefillPerSecond.
+	BurstSize int64
+	// RefillPerSecond is the steady-state number of tokens added to the
+	// bucket per second.
+	RefillPerSecond float64
+	// PerRoleOverrides replaces BurstSize/RefillPerSecond for an identity
+	// holding one of these roles. When an identity holds more than one
+	// overridden role, the most generous override (by BurstSize) wins.
+	PerRoleOverrides map[string]RateLimitConfig
+}
+
+// CheckAndSetDefaults validates c, filling in DefaultBurstSize and
+// DefaultRefillPerSecond for zero-valued fields.
+func (c *RateLimitConfig) CheckAndSetDefaults() error {
+	if c.BurstSize == 0 {
+		c.BurstSize = DefaultBurstSize
+	}
+	if c.RefillPerSecond == 0 {
+		c.RefillPerSecond = DefaultRefillPerSecond
+	}
+	if c.BurstSize < 0 {
+		return trace.BadParameter("BurstSize must be positive")
+	}
+	if c.RefillPerSecond <= 0 {
+		return trace.BadParameter("RefillPerSecond must be positive")
+	}
+	for role, override := range c.PerRoleOverrides {
+		if err := override.CheckAndSetDefaults(); err != nil {
+			return trace.Wrap(err, "role override %q", role)
+		}
+	}
+	return nil
+}
+
+// effective returns the limit that applies to an identity holding roles,
+// taking the most generous PerRoleOverrides entry among them.
+func (c RateLimitConfig) effective(roles []string) RateLimitConfig {
+	out := c
+	for _, role := range roles {
+		override, ok := c.PerRoleOverrides[role]
+		if ok && override.BurstSize > out.BurstSize {
+			out.BurstSize, out.RefillPerSecond = override.BurstSize, override.RefillPerSecond
+		}
+	}
+	return out
+}
+
+// AuditEmitter records rate-limit decisions for the security audit log.
+// It's deliberately narrower than the auth server's general audit
+// emitter so this package stays testable without pulling in the full
+// audit event hierarchy.
+type AuditEmitter interface {
+	// EmitRateLimitExceeded is called after an identity has been
+	// rejected for exceeding its rate limit.
+	EmitRateLimitExceeded(ctx context.Context, identity, role string) error
+}
+
+// identityMonitor tracks one identity's token bucket and its sampled
+// exponentially-weighted moving average request rate, mirroring the
+// classic flowcontrol.Monitor pattern of EMA-based rate sampling behind
+// a single mutex.
+type identityMonitor struct {
+	mu sync.Mutex
+
+	capacity     float64
+	refillPerSec float64
+	tokens       float64
+	lastRefill   time.Time
+
+	emaRate    float64
+	lastSample time.Time
+
+	// lastAccess is the last time reserve was called for this identity,
+	// used by idle to decide when the monitor can be evicted.
+	lastAccess time.Time
+}
+
+func newIdentityMonitor(now time.Time, cfg RateLimitConfig) *identityMonitor {
+	return &identityMonitor{
+		capacity:     float64(cfg.BurstSize),
+		refillPerSec: cfg.RefillPerSecond,
+		tokens:       float64(cfg.BurstSize),
+		lastRefill:   now,
+		lastSample:   now,
+		lastAccess:   now,
+	}
+}
+
+// reserve refills the bucket for elapsed time and either takes a token
+// (wait is 0, ok is true) or reports how long the caller must wait for
+// the next token to become available (ok is false).
+func (m *identityMonitor) reserve(now time.Time) (wait time.Duration, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastAccess = now
+	if elapsed := now.Sub(m.lastRefill).Seconds(); elapsed > 0 {
+		m.tokens = min(m.capacity, m.tokens+elapsed*m.refillPerSec)
+		m.lastRefill = now
+	}
+	m.sampleLocked(now)
+
+	if m.tokens >= 1 {
+		m.tokens--
+		return 0, true
+	}
+	missing := 1 - m.tokens
+	return time.Duration(missing / m.refillPerSec * float64(time.Second)), false
+}
+
+// sampleLocked folds the instantaneous rate implied by the gap since the
+// last sample into the moving average. m.mu must be held.
+func (m *identityMonitor) sampleLocked(now time.Time) {
+	interval := now.Sub(m.lastSample).Seconds()
+	if interval <= 0 {
+		return
+	}
+	instantRate := 1 / interval
+	decay := math.Exp(-interval * math.Ln2 / emaHalfLife.Seconds())
+	m.emaRate = m.emaRate*decay + instantRate*(1-decay)
+	m.lastSample = now
+}
+
+func (m *identityMonitor) snapshot() (emaRate, fill float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.emaRate, m.tokens
+}
+
+// idle reports whether this monitor's bucket is full (so evicting it
+// loses no pending throttling state) and it hasn't been touched in ttl,
+// making it safe to evict. tokens is only refilled lazily inside
+// reserve, so idle projects the refill as of now itself rather than
+// reading the possibly long-stale m.tokens.
+func (m *identityMonitor) idle(now time.Time, ttl time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastAccess) < ttl {
+		return false
+	}
+	projected := min(m.capacity, m.tokens+now.Sub(m.lastRefill).Seconds()*m.refillPerSec)
+	return projected >= m.capacity
+}
+
+// RateLimiter enforces a per-identity token-bucket limit across
+// presencev1's RPCs, so a single noisy user, bot, or node can't starve
+// the auth server. It's intentionally reusable by any v1 gRPC service:
+// wrap each handler's entry point with Reserve.
+type RateLimiter struct {
+	cfg   RateLimitConfig
+	clock clockwork.Clock
+	audit AuditEmitter
+
+	mu       sync.Mutex
+	monitors map[string]*identityMonitor
+	lastGC   time.Time
+
+	emaRate    *prometheus.GaugeVec
+	bucketFill *prometheus.GaugeVec
+	rejections *prometheus.CounterVec
+}
+
+// NewRateLimiter returns a RateLimiter enforcing cfg, sampling time from
+// clock (clockwork.NewRealClock() if nil) and reporting rejections
+// through audit (which may be nil to skip audit logging, e.g. in tests).
+func NewRateLimiter(cfg RateLimitConfig, clock clockwork.Clock, audit AuditEmitter) (*RateLimiter, error) {
+	if err := cfg.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+
+	return &RateLimiter{
+		cfg:      cfg,
+		clock:    clock,
+		audit:    audit,
+		monitors: make(map[string]*identityMonitor),
+		lastGC:   clock.Now(),
+		// Labeled by role rather than identity: role is drawn from a
+		// cluster's (bounded) set of defined roles, while identity is
+		// one label value per distinct caller the auth server has ever
+		// seen, which grows without bound over its lifetime.
+		emaRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: "presence",
+			Name:      "rate_limit_ema_request_rate",
+			Help:      "Sampled exponentially-weighted moving average request rate, in requests per second, per role.",
+		}, []string{"role"}),
+		bucketFill: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: "presence",
+			Name:      "rate_limit_bucket_tokens",
+			Help:      "Tokens currently available in a rate-limit bucket, per role.",
+		}, []string{"role"}),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Subsystem: "presence",
+			Name:      "rate_limit_rejections_total",
+			Help:      "Requests rejected for exceeding the per-identity rate limit, per role.",
+		}, []string{"role"}),
+	}, nil
+}
+
+// Collectors returns the limiter's Prometheus collectors, so the auth
+// server can register them alongside its other metrics.
+func (l *RateLimiter) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{l.emaRate, l.bucketFill, l.rejections}
+}
+
+// Reserve blocks until identity (optionally holding roles, for
+// PerRoleOverrides) has a token available, ctx is canceled, or deadline
+// elapses, whichever comes first. It returns trace.LimitExceeded without
+// waiting when the next token would not arrive before deadline.
+func (l *RateLimiter) Reserve(ctx context.Context, identity string, roles []string, deadline time.Time) error {
+	monitor := l.monitorFor(identity, roles)
+	role := primaryRole(roles)
+
+	for {
+		now := l.clock.Now()
+		wait, ok := monitor.reserve(now)
+
+		emaRate, fill := monitor.snapshot()
+		l.emaRate.WithLabelValues(role).Set(emaRate)
+		l.bucketFill.WithLabelValues(role).Set(fill)
+
+		if ok {
+			return nil
+		}
+
+		remaining := deadline.Sub(now)
+		if remaining <= 0 || wait > remaining {
+			l.rejections.WithLabelValues(role).Inc()
+			if l.audit != nil {
+				if auditErr := l.audit.EmitRateLimitExceeded(ctx, identity, primaryRole(roles)); auditErr != nil {
+					return trace.NewAggregate(
+						trace.LimitExceeded("rate limit exceeded for %q", identity),
+						trace.Wrap(auditErr, "emitting rate limit audit event"),
+					)
+				}
+			}
+			return trace.LimitExceeded("rate limit exceeded for %q", identity)
+		}
+
+		select {
+		case <-ctx.Done():
+			return trace.Wrap(ctx.Err())
+		case <-l.clock.After(wait):
+		}
+	}
+}
+
+func (l *RateLimiter) monitorFor(identity string, roles []string) *identityMonitor {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if now.Sub(l.lastGC) >= monitorGCInterval {
+		l.evictIdleMonitorsLocked(now)
+		l.lastGC = now
+	}
+
+	monitor, ok := l.monitors[identity]
+	if !ok {
+		monitor = newIdentityMonitor(now, l.cfg.effective(roles))
+		l.monitors[identity] = monitor
+	}
+	return monitor
+}
+
+// evictIdleMonitorsLocked removes every monitor that's been idle for at
+// least idleMonitorTTL, so a long-lived auth server doesn't accumulate
+// one identityMonitor per distinct caller it has ever seen. l.mu must be
+// held.
+func (l *RateLimiter) evictIdleMonitorsLocked(now time.Time) {
+	for identity, monitor := range l.monitors {
+		if monitor.idle(now, idleMonitorTTL) {
+			delete(l.monitors, identity)
+		}
+	}
+}
+
+func primaryRole(roles []string) string {
+	if len(roles) == 0 {
+		return ""
+	}
+	return roles[0]
+}
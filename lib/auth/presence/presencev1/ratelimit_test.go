@@ -0,0 +1,162 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package presencev1
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuditEmitter struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (f *fakeAuditEmitter) EmitRateLimitExceeded(_ context.Context, identity, role string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, identity+"/"+role)
+	return nil
+}
+
+func (f *fakeAuditEmitter) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.events)
+}
+
+func TestRateLimiterAllowsBurstThenThrottles(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	audit := &fakeAuditEmitter{}
+	limiter, err := NewRateLimiter(RateLimitConfig{BurstSize: 3, RefillPerSecond: 1}, clock, audit)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	deadline := clock.Now()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, limiter.Reserve(ctx, "alice", nil, deadline))
+	}
+
+	err = limiter.Reserve(ctx, "alice", nil, deadline)
+	require.Error(t, err)
+	require.True(t, trace.IsLimitExceeded(err), "expected LimitExceeded, got %v", err)
+	require.Equal(t, 1, audit.count())
+}
+
+func TestRateLimiterRefillsOverTime(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	limiter, err := NewRateLimiter(RateLimitConfig{BurstSize: 1, RefillPerSecond: 1}, clock, nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, limiter.Reserve(ctx, "bob", nil, clock.Now()))
+
+	// The bucket is now empty; a zero-wait deadline must fail immediately.
+	require.Error(t, limiter.Reserve(ctx, "bob", nil, clock.Now()))
+
+	// But waiting past the deadline should succeed, since Reserve sleeps
+	// on the fake clock until a token refills.
+	done := make(chan error, 1)
+	go func() {
+		done <- limiter.Reserve(ctx, "bob", nil, clock.Now().Add(2*time.Second))
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+
+	require.NoError(t, <-done)
+}
+
+func TestRateLimiterRespectsContextCancellation(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	limiter, err := NewRateLimiter(RateLimitConfig{BurstSize: 1, RefillPerSecond: 1}, clock, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, limiter.Reserve(ctx, "carol", nil, clock.Now()))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- limiter.Reserve(ctx, "carol", nil, clock.Now().Add(time.Minute))
+	}()
+
+	clock.BlockUntil(1)
+	cancel()
+
+	err = <-done
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRateLimiterPerRoleOverrideTakesMostGenerous(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	limiter, err := NewRateLimiter(RateLimitConfig{
+		BurstSize:       1,
+		RefillPerSecond: 1,
+		PerRoleOverrides: map[string]RateLimitConfig{
+			"bot": {BurstSize: 5, RefillPerSecond: 5},
+		},
+	}, clock, nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, limiter.Reserve(ctx, "build-bot", []string{"bot"}, clock.Now()))
+	}
+	require.Error(t, limiter.Reserve(ctx, "build-bot", []string{"bot"}, clock.Now()))
+}
+
+func TestRateLimiterEvictsIdleMonitors(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	limiter, err := NewRateLimiter(RateLimitConfig{BurstSize: 1, RefillPerSecond: 1}, clock, nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, limiter.Reserve(ctx, "dana", nil, clock.Now()))
+	require.Len(t, limiter.monitors, 1)
+
+	// The bucket refills to full while dana makes no further calls, so by
+	// the time it's next swept it's both full and idle.
+	clock.Advance(idleMonitorTTL + monitorGCInterval)
+
+	// monitorFor only sweeps as a side effect of being called; eve's call
+	// is what triggers the sweep that evicts dana's now-idle monitor.
+	require.NoError(t, limiter.Reserve(ctx, "eve", nil, clock.Now()))
+
+	limiter.mu.Lock()
+	_, danaStillTracked := limiter.monitors["dana"]
+	limiter.mu.Unlock()
+	require.False(t, danaStillTracked, "idle monitor should have been evicted")
+}
+
+func TestRateLimitConfigCheckAndSetDefaults(t *testing.T) {
+	cfg := RateLimitConfig{}
+	require.NoError(t, cfg.CheckAndSetDefaults())
+	require.EqualValues(t, DefaultBurstSize, cfg.BurstSize)
+	require.Equal(t, float64(DefaultRefillPerSecond), cfg.RefillPerSecond)
+
+	bad := RateLimitConfig{RefillPerSecond: -1}
+	require.True(t, trace.IsBadParameter(bad.CheckAndSetDefaults()))
+}
@@ -0,0 +1,160 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package presencev1
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/jonboulle/clockwork"
+
+	presencev1pb "github.com/gravitational/teleport/api/gen/proto/go/teleport/presence/v1"
+	"github.com/gravitational/teleport/api/types"
+)
+
+// reserveDeadline bounds how long a single RPC waits for the rate
+// limiter to free up a token before giving up. A caller that hits this
+// gets trace.LimitExceeded instead of a handler goroutine piling up
+// behind a sustained burst.
+const reserveDeadline = 5 * time.Second
+
+// Checker authorizes the calling identity's access to presence
+// resources and resolves the identity the RateLimiter keys and scopes
+// its buckets by. It's narrower than the auth server's general
+// authorizer, the same way embeddingv1.Checker is, so this package stays
+// testable without pulling in the full RBAC stack.
+type Checker interface {
+	// CheckAccess returns an error unless the caller may perform verb
+	// (e.g. types.VerbRead) against kind (e.g. types.KindRemoteCluster),
+	// applying whatever label matchers the caller's roles declare.
+	CheckAccess(ctx context.Context, kind, verb string) error
+	// Identity returns the calling identity's username and roles.
+	Identity(ctx context.Context) (username string, roles []string, err error)
+}
+
+// Backend is the subset of the presence service's storage this package's
+// RPC handlers delegate to.
+type Backend interface {
+	GetRemoteCluster(ctx context.Context, name string) (types.RemoteCluster, error)
+	GetRemoteClusters(ctx context.Context) ([]types.RemoteCluster, error)
+}
+
+// Service implements the server side of the teleport.presence.v1 RPCs
+// covered by this package, authorizing each call through a Checker and
+// throttling it through a RateLimiter before it reaches backend.
+type Service struct {
+	backend Backend
+	checker Checker
+	limiter *RateLimiter
+	clock   clockwork.Clock
+}
+
+// ServiceOption customizes a Service returned by NewService.
+type ServiceOption func(*Service)
+
+// WithServiceClock overrides the clock used to compute each RPC's
+// rate-limit reservation deadline. Defaults to the real clock; tests can
+// inject a clockwork.FakeClock to make the deadline deterministic.
+func WithServiceClock(clock clockwork.Clock) ServiceOption {
+	return func(s *Service) { s.clock = clock }
+}
+
+// NewService returns a Service backed by backend, gating every RPC
+// through checker and limiter.
+func NewService(backend Backend, checker Checker, limiter *RateLimiter, opts ...ServiceOption) (*Service, error) {
+	if backend == nil {
+		return nil, trace.BadParameter("backend is required")
+	}
+	if checker == nil {
+		return nil, trace.BadParameter("checker is required")
+	}
+	if limiter == nil {
+		return nil, trace.BadParameter("limiter is required")
+	}
+	s := &Service{
+		backend: backend,
+		checker: checker,
+		limiter: limiter,
+		clock:   clockwork.NewRealClock(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// reserve authorizes verb against kind and, once authorized, waits for a
+// rate-limit token for the caller's identity. Rate-limiting only the
+// authorized path keeps an unauthorized caller's rejected requests from
+// consuming a legitimate identity's token-bucket budget.
+func (s *Service) reserve(ctx context.Context, kind, verb string) error {
+	if err := s.checker.CheckAccess(ctx, kind, verb); err != nil {
+		return trace.Wrap(err)
+	}
+	username, roles, err := s.checker.Identity(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := s.limiter.Reserve(ctx, username, roles, s.clock.Now().Add(reserveDeadline)); err != nil {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// GetRemoteCluster implements the GetRemoteCluster RPC.
+func (s *Service) GetRemoteCluster(ctx context.Context, req *presencev1pb.GetRemoteClusterRequest) (*types.RemoteClusterV3, error) {
+	if req.GetName() == "" {
+		return nil, trace.BadParameter("name must be specified")
+	}
+	if err := s.reserve(ctx, types.KindRemoteCluster, types.VerbRead); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	rc, err := s.backend.GetRemoteCluster(ctx, req.GetName())
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	v3, ok := rc.(*types.RemoteClusterV3)
+	if !ok {
+		return nil, trace.BadParameter("unexpected remote cluster type %T", rc)
+	}
+	return v3, nil
+}
+
+// ListRemoteClusters implements the ListRemoteClusters RPC.
+func (s *Service) ListRemoteClusters(ctx context.Context, _ *presencev1pb.ListRemoteClustersRequest) ([]*types.RemoteClusterV3, error) {
+	if err := s.reserve(ctx, types.KindRemoteCluster, types.VerbList); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	rcs, err := s.backend.GetRemoteClusters(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	out := make([]*types.RemoteClusterV3, 0, len(rcs))
+	for _, rc := range rcs {
+		v3, ok := rc.(*types.RemoteClusterV3)
+		if !ok {
+			return nil, trace.BadParameter("unexpected remote cluster type %T", rc)
+		}
+		out = append(out, v3)
+	}
+	return out, nil
+}
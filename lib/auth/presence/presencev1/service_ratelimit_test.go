@@ -0,0 +1,133 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package presencev1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gravitational/trace"
+	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/require"
+
+	presencev1pb "github.com/gravitational/teleport/api/gen/proto/go/teleport/presence/v1"
+	"github.com/gravitational/teleport/api/types"
+)
+
+type fakeBackend struct {
+	clusters map[string]*types.RemoteClusterV3
+}
+
+func (f *fakeBackend) GetRemoteCluster(_ context.Context, name string) (types.RemoteCluster, error) {
+	rc, ok := f.clusters[name]
+	if !ok {
+		return nil, trace.NotFound("remote cluster %q is not found", name)
+	}
+	return rc, nil
+}
+
+func (f *fakeBackend) GetRemoteClusters(_ context.Context) ([]types.RemoteCluster, error) {
+	out := make([]types.RemoteCluster, 0, len(f.clusters))
+	for _, rc := range f.clusters {
+		out = append(out, rc)
+	}
+	return out, nil
+}
+
+type fakeChecker struct {
+	username string
+	roles    []string
+	denyKind string
+}
+
+func (f *fakeChecker) CheckAccess(_ context.Context, kind, _ string) error {
+	if kind == f.denyKind {
+		return trace.AccessDenied("access denied for kind %q", kind)
+	}
+	return nil
+}
+
+func (f *fakeChecker) Identity(_ context.Context) (string, []string, error) {
+	return f.username, f.roles, nil
+}
+
+func TestServiceGetRemoteClusterRequiresName(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimitConfig{}, clockwork.NewFakeClock(), nil)
+	require.NoError(t, err)
+	svc, err := NewService(&fakeBackend{}, &fakeChecker{username: "alice"}, limiter)
+	require.NoError(t, err)
+
+	_, err = svc.GetRemoteCluster(context.Background(), &presencev1pb.GetRemoteClusterRequest{})
+	require.True(t, trace.IsBadParameter(err), "expected bad parameter, got %v", err)
+}
+
+func TestServiceGetRemoteClusterDeniesUnauthorized(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimitConfig{}, clockwork.NewFakeClock(), nil)
+	require.NoError(t, err)
+	svc, err := NewService(&fakeBackend{clusters: map[string]*types.RemoteClusterV3{
+		"leaf": {},
+	}}, &fakeChecker{username: "alice", denyKind: types.KindRemoteCluster}, limiter)
+	require.NoError(t, err)
+
+	_, err = svc.GetRemoteCluster(context.Background(), &presencev1pb.GetRemoteClusterRequest{Name: "leaf"})
+	require.True(t, trace.IsAccessDenied(err), "expected access denied, got %v", err)
+}
+
+func TestServiceGetRemoteClusterEnforcesRateLimit(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	// RefillPerSecond is deliberately slower than reserveDeadline so the
+	// second call's wait exceeds the deadline and rejects immediately,
+	// rather than sleeping against a clock nothing in this test advances.
+	limiter, err := NewRateLimiter(RateLimitConfig{BurstSize: 1, RefillPerSecond: 0.1}, clock, nil)
+	require.NoError(t, err)
+	svc, err := NewService(&fakeBackend{clusters: map[string]*types.RemoteClusterV3{
+		"leaf": {},
+	}}, &fakeChecker{username: "alice"}, limiter, WithServiceClock(clock))
+	require.NoError(t, err)
+
+	req := &presencev1pb.GetRemoteClusterRequest{Name: "leaf"}
+	_, err = svc.GetRemoteCluster(context.Background(), req)
+	require.NoError(t, err)
+
+	_, err = svc.GetRemoteCluster(context.Background(), req)
+	require.True(t, trace.IsLimitExceeded(err), "expected limit exceeded, got %v", err)
+}
+
+func TestServiceListRemoteClustersRateLimitsIndependentlyPerIdentity(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	limiter, err := NewRateLimiter(RateLimitConfig{BurstSize: 1, RefillPerSecond: 0.1}, clock, nil)
+	require.NoError(t, err)
+	backend := &fakeBackend{clusters: map[string]*types.RemoteClusterV3{"leaf": {}}}
+
+	aliceSvc, err := NewService(backend, &fakeChecker{username: "alice"}, limiter, WithServiceClock(clock))
+	require.NoError(t, err)
+	bobSvc, err := NewService(backend, &fakeChecker{username: "bob"}, limiter, WithServiceClock(clock))
+	require.NoError(t, err)
+
+	_, err = aliceSvc.ListRemoteClusters(context.Background(), &presencev1pb.ListRemoteClustersRequest{})
+	require.NoError(t, err)
+
+	// alice's own next call is throttled...
+	_, err = aliceSvc.ListRemoteClusters(context.Background(), &presencev1pb.ListRemoteClustersRequest{})
+	require.True(t, trace.IsLimitExceeded(err), "expected limit exceeded, got %v", err)
+
+	// ...but bob has his own bucket.
+	_, err = bobSvc.ListRemoteClusters(context.Background(), &presencev1pb.ListRemoteClustersRequest{})
+	require.NoError(t, err)
+}
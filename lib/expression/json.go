@@ -0,0 +1,40 @@
+package expression
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// jsonParse unmarshals input into a generic Go value (map[string]any,
+// []any, string, float64, bool, or nil), so traits that arrive as a raw
+// JSON string (e.g. an OIDC claim) can be projected with json.get
+// without a separate transform step before the role is evaluated.
+func jsonParse(input string) (any, error) {
+	var out any
+	if err := json.Unmarshal([]byte(input), &out); err != nil {
+		return nil, trace.Wrap(err, "parsing json")
+	}
+	return out, nil
+}
+
+// jsonGet selects the value at the dotted path (e.g. "a.b.c") within
+// obj, descending through nested objects one segment at a time. It
+// returns trace.NotFound if a segment is missing and trace.BadParameter
+// if a non-final segment isn't an object.
+func jsonGet(obj any, path string) (any, error) {
+	current := obj
+	for _, segment := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, trace.BadParameter("json.get: cannot select %q, value is %T, not an object", segment, current)
+		}
+		value, ok := m[segment]
+		if !ok {
+			return nil, trace.NotFound("json.get: field %q not found", segment)
+		}
+		current = value
+	}
+	return current, nil
+}
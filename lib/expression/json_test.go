@@ -0,0 +1,41 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONParseAndGet(t *testing.T) {
+	parsed, err := jsonParse(`{"a": {"b": {"c": "value"}}, "n": 4}`)
+	require.NoError(t, err)
+
+	got, err := jsonGet(parsed, "a.b.c")
+	require.NoError(t, err)
+	require.Equal(t, "value", got)
+
+	got, err = jsonGet(parsed, "n")
+	require.NoError(t, err)
+	require.Equal(t, float64(4), got)
+}
+
+func TestJSONParseInvalidInput(t *testing.T) {
+	_, err := jsonParse(`not json`)
+	require.Error(t, err)
+}
+
+func TestJSONGetMissingField(t *testing.T) {
+	parsed, err := jsonParse(`{"a": 1}`)
+	require.NoError(t, err)
+
+	_, err = jsonGet(parsed, "b")
+	require.Error(t, err)
+}
+
+func TestJSONGetNonObjectSegment(t *testing.T) {
+	parsed, err := jsonParse(`{"a": "scalar"}`)
+	require.NoError(t, err)
+
+	_, err = jsonGet(parsed, "a.b")
+	require.Error(t, err)
+}
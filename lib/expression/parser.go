@@ -1,7 +1,10 @@
 package expression
 
 import (
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gravitational/trace"
 
@@ -84,6 +87,58 @@ func DefaultParserSpec[evaluationEnv any]() typical.ParserSpec {
 					}
 					return NewSet(outputs...), nil
 				}),
+			"regexp.match": typical.BinaryFunction[evaluationEnv](
+				func(input any, pattern string) (bool, error) {
+					re, err := defaultRegexpCache.compile(pattern)
+					if err != nil {
+						return false, trace.Wrap(err)
+					}
+					return regexpMatchAny(re, input)
+				}),
+			"regexp.find_all": typical.BinaryFunction[evaluationEnv](
+				func(inputs Set, pattern string) (Set, error) {
+					re, err := defaultRegexpCache.compile(pattern)
+					if err != nil {
+						return nil, trace.Wrap(err)
+					}
+					var matches []string
+					for input := range inputs {
+						matches = append(matches, re.FindAllString(input, -1)...)
+					}
+					return NewSet(matches...), nil
+				}),
+			"json.parse": typical.UnaryFunction[evaluationEnv](
+				func(input string) (any, error) {
+					return jsonParse(input)
+				}),
+			"json.get": typical.BinaryFunction[evaluationEnv](
+				func(obj any, path string) (any, error) {
+					return jsonGet(obj, path)
+				}),
+			"time.parse": typical.BinaryFunction[evaluationEnv](
+				func(input string, layout string) (time.Time, error) {
+					return timeParse(input, layout)
+				}),
+			"time.now": typical.NullaryFunction[evaluationEnv](
+				func() (time.Time, error) {
+					return time.Now(), nil
+				}),
+			"time.before": typical.BinaryFunction[evaluationEnv](
+				func(a, b time.Time) (bool, error) {
+					return timeBefore(a, b), nil
+				}),
+			"time.after": typical.BinaryFunction[evaluationEnv](
+				func(a, b time.Time) (bool, error) {
+					return timeAfter(a, b), nil
+				}),
+			"set.intersect": typical.UnaryVariadicFunction[evaluationEnv](
+				func(sets ...Set) (Set, error) {
+					return intersect(sets...), nil
+				}),
+			"set.difference": typical.BinaryFunction[evaluationEnv](
+				func(a, b Set) (Set, error) {
+					return difference(a, b), nil
+				}),
 		},
 		Methods: map[string]typical.Function{
 			"add": typical.BinaryVariadicFunction[evaluationEnv](
@@ -106,6 +161,13 @@ func DefaultParserSpec[evaluationEnv any]() typical.ParserSpec {
 				func(r remover, items ...string) (any, error) {
 					return r.remove(items...), nil
 				}),
+			"get": typical.TernaryFunction[evaluationEnv](
+				func(d Dict, key string, defaultValue Set) (Set, error) {
+					if v, ok := d[key]; ok {
+						return v, nil
+					}
+					return defaultValue, nil
+				}),
 		},
 	}
 }
@@ -123,13 +185,19 @@ func NewTraitsExpressionParser[TEnv any](vars evaluationEnvVar) (*typical.Parser
 }
 
 // traitsMapResultToSet returns Set for result type string or Set and errors if the result
-// cannot be evaluated to either Set or string.
+// cannot be evaluated to either Set or string. bool and time.Time are also accepted, formatted
+// to their string representation, since regexp.match and time.parse/time.now can now produce
+// them as a final expression result.
 func traitsMapResultToSet(result any, expr string) (Set, error) {
 	switch v := result.(type) {
 	case string:
 		return NewSet(v), nil
 	case Set:
 		return v, nil
+	case bool:
+		return NewSet(strconv.FormatBool(v)), nil
+	case time.Time:
+		return NewSet(v.Format(time.RFC3339)), nil
 	default:
 		return nil, trace.BadParameter("traits_map expression must evaluate to type string or set, the following expression evaluates to %T: %q", result, expr)
 	}
@@ -165,6 +233,27 @@ func StringTransform(name string, input any, f func(string) string) (any, error)
 	}
 }
 
+// regexpMatchAny reports whether re matches input, a string, or any
+// element of input, a Set - mirroring StringTransform's string-or-Set
+// dispatch, but collapsing a Set down to a single bool rather than
+// transforming it element-by-element, since regexp.match answers
+// whether a match exists, not what it is.
+func regexpMatchAny(re *regexp.Regexp, input any) (bool, error) {
+	switch typedInput := input.(type) {
+	case string:
+		return re.MatchString(typedInput), nil
+	case Set:
+		for item := range typedInput {
+			if re.MatchString(item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, trace.BadParameter("failed to evaluate argument to regexp.match: expected string or set, got value of type %T", input)
+	}
+}
+
 // remover is an interface used so that the parser can call the "remove" method
 // on both set and dict.
 type remover interface {
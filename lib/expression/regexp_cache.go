@@ -0,0 +1,45 @@
+package expression
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/gravitational/trace"
+)
+
+// regexpCache compiles and caches *regexp.Regexp by pattern string, so
+// that regexp.match and regexp.find_all don't recompile the same
+// pattern on every evaluation of a role template or traits_map
+// expression (which can run once per login).
+type regexpCache struct {
+	mu        sync.RWMutex
+	byPattern map[string]*regexp.Regexp
+}
+
+func newRegexpCache() *regexpCache {
+	return &regexpCache{byPattern: make(map[string]*regexp.Regexp)}
+}
+
+func (c *regexpCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.RLock()
+	re, ok := c.byPattern[pattern]
+	c.mu.RUnlock()
+	if ok {
+		return re, nil
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, trace.Wrap(err, "compiling regexp %q", pattern)
+	}
+
+	c.mu.Lock()
+	c.byPattern[pattern] = compiled
+	c.mu.Unlock()
+	return compiled, nil
+}
+
+// defaultRegexpCache backs regexp.match and regexp.find_all. A single
+// package-level cache is sufficient since parsers are long-lived and
+// patterns come from a small, fixed set of role templates.
+var defaultRegexpCache = newRegexpCache()
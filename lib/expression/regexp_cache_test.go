@@ -0,0 +1,28 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegexpCacheCompilesAndReusesPattern(t *testing.T) {
+	cache := newRegexpCache()
+
+	re, err := cache.compile(`^foo-\d+$`)
+	require.NoError(t, err)
+	require.True(t, re.MatchString("foo-123"))
+	require.False(t, re.MatchString("bar-123"))
+
+	// A second compile of the same pattern must return the cached
+	// *regexp.Regexp, not recompile it.
+	again, err := cache.compile(`^foo-\d+$`)
+	require.NoError(t, err)
+	require.Same(t, re, again)
+}
+
+func TestRegexpCacheReturnsErrorForInvalidPattern(t *testing.T) {
+	cache := newRegexpCache()
+	_, err := cache.compile(`(unclosed`)
+	require.Error(t, err)
+}
@@ -0,0 +1,35 @@
+package expression
+
+// intersect returns the items present in every one of sets. It returns
+// an empty Set if sets is empty.
+func intersect(sets ...Set) Set {
+	if len(sets) == 0 {
+		return NewSet()
+	}
+
+	var common []string
+	for _, item := range sets[0].items() {
+		inAll := true
+		for _, other := range sets[1:] {
+			if !other.contains(item) {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			common = append(common, item)
+		}
+	}
+	return NewSet(common...)
+}
+
+// difference returns the items in a that are not present in b.
+func difference(a, b Set) Set {
+	var remaining []string
+	for _, item := range a.items() {
+		if !b.contains(item) {
+			remaining = append(remaining, item)
+		}
+	}
+	return NewSet(remaining...)
+}
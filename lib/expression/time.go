@@ -0,0 +1,24 @@
+package expression
+
+import (
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// timeParse parses input as layout (a reference-time layout, as
+// accepted by time.Parse), returning trace.BadParameter if it doesn't
+// match.
+func timeParse(input, layout string) (time.Time, error) {
+	t, err := time.Parse(layout, input)
+	if err != nil {
+		return time.Time{}, trace.BadParameter("time.parse: %v", err)
+	}
+	return t, nil
+}
+
+// timeBefore and timeAfter expose time.Time's ordering as expression
+// functions; time.Time values returned by time.parse/time.now also
+// support the parser's native comparison operators directly.
+func timeBefore(a, b time.Time) bool { return a.Before(b) }
+func timeAfter(a, b time.Time) bool  { return a.After(b) }
@@ -0,0 +1,158 @@
+/*
+ * Teleport
+ * Copyright (C) 2023  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package services
+
+import (
+	"encoding/json"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/teleport/api/types"
+)
+
+// MarshalOption customizes an Unmarshal* call with backend bookkeeping
+// that isn't part of the resource's own wire format, e.g. the resource ID
+// and revision assigned by the backend item the bytes were read from.
+type MarshalOption func(*MarshalConfig)
+
+// MarshalConfig collects the options applied by the With* helpers below.
+type MarshalConfig struct {
+	// ResourceID is the backend-assigned resource ID to apply to the
+	// unmarshaled value.
+	ResourceID int64
+	// Revision is the backend revision to apply to the unmarshaled
+	// value.
+	Revision string
+}
+
+// CollectOptions applies opts in order and returns the resulting config.
+func CollectOptions(opts []MarshalOption) (*MarshalConfig, error) {
+	var cfg MarshalConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &cfg, nil
+}
+
+// WithResourceID sets the backend-assigned resource ID on the value
+// returned by an Unmarshal* call.
+func WithResourceID(id int64) MarshalOption {
+	return func(cfg *MarshalConfig) { cfg.ResourceID = id }
+}
+
+// WithRevision sets the backend revision on the value returned by an
+// Unmarshal* call, so callers can issue a conditional update later
+// without a read-modify-write race.
+func WithRevision(revision string) MarshalOption {
+	return func(cfg *MarshalConfig) { cfg.Revision = revision }
+}
+
+// ValidateCAProvisioner checks that provisioner is well-formed.
+func ValidateCAProvisioner(provisioner types.CAProvisioner) error {
+	if provisioner == nil {
+		return trace.BadParameter("missing CA provisioner")
+	}
+	if err := provisioner.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// caProvisionerEnvelope tags the marshaled bytes of a CAProvisioner with
+// its concrete variant, so UnmarshalCAProvisioner knows which Go type to
+// decode the data into.
+type caProvisionerEnvelope struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+// MarshalCAProvisioner marshals a CAProvisioner into its wire
+// representation.
+func MarshalCAProvisioner(provisioner types.CAProvisioner) ([]byte, error) {
+	if err := ValidateCAProvisioner(provisioner); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	data, err := json.Marshal(provisioner)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	out, err := json.Marshal(caProvisionerEnvelope{
+		Kind: string(provisioner.GetProvisionerType()),
+		Data: data,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return out, nil
+}
+
+// UnmarshalCAProvisioner unmarshals a CAProvisioner from its wire
+// representation, dispatching to the concrete variant tagged in the
+// envelope.
+func UnmarshalCAProvisioner(data []byte, opts ...MarshalOption) (types.CAProvisioner, error) {
+	cfg, err := CollectOptions(opts)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var envelope caProvisionerEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var provisioner types.CAProvisioner
+	switch types.CAProvisionerType(envelope.Kind) {
+	case types.CAProvisionerTypeACME:
+		var p types.ACMEProvisioner
+		if err := json.Unmarshal(envelope.Data, &p); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		provisioner = &p
+	case types.CAProvisionerTypeJWK:
+		var p types.JWKProvisioner
+		if err := json.Unmarshal(envelope.Data, &p); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		provisioner = &p
+	case types.CAProvisionerTypeKMS:
+		var p types.KMSProvisioner
+		if err := json.Unmarshal(envelope.Data, &p); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		provisioner = &p
+	case types.CAProvisionerTypeNebula:
+		var p types.NebulaProvisioner
+		if err := json.Unmarshal(envelope.Data, &p); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		provisioner = &p
+	default:
+		return nil, trace.BadParameter("unsupported CA provisioner kind %q", envelope.Kind)
+	}
+
+	if err := ValidateCAProvisioner(provisioner); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	provisioner.SetResourceID(cfg.ResourceID)
+	provisioner.SetRevision(cfg.Revision)
+	return provisioner, nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package embeddingindex
+
+import (
+	"math/rand"
+	"testing"
+)
+
+const benchDim = 64
+
+func benchmarkBruteForceSearch(b *testing.B, n int) {
+	index := NewBruteForce(MetricCosine)
+	index.Add(randomItems(1, n, benchDim)...)
+	rng := rand.New(rand.NewSource(2))
+	query := randomVector(rng, benchDim)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Search(query, 10)
+	}
+}
+
+func benchmarkHNSWSearch(b *testing.B, n int) {
+	index := NewHNSW(MetricCosine, HNSWConfig{})
+	index.Add(randomItems(1, n, benchDim)...)
+	rng := rand.New(rand.NewSource(2))
+	query := randomVector(rng, benchDim)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Search(query, 10)
+	}
+}
+
+// BenchmarkBruteForceSearch10k and BenchmarkHNSWSearch10k demonstrate
+// HNSW's sub-linear query time against the brute-force baseline: run
+// with `go test -bench . -benchtime 1x` and compare ns/op against the
+// 100k variants below - brute force scales roughly linearly with n,
+// HNSW roughly logarithmically.
+func BenchmarkBruteForceSearch10k(b *testing.B) { benchmarkBruteForceSearch(b, 10_000) }
+func BenchmarkHNSWSearch10k(b *testing.B)       { benchmarkHNSWSearch(b, 10_000) }
+
+func BenchmarkBruteForceSearch100k(b *testing.B) { benchmarkBruteForceSearch(b, 100_000) }
+func BenchmarkHNSWSearch100k(b *testing.B)       { benchmarkHNSWSearch(b, 100_000) }
@@ -0,0 +1,122 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package embeddingindex
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// BruteForce is an exact Index that scores every item on every Search
+// call. It's the simplest correct baseline, and a reasonable choice for
+// small kinds where an HNSW graph's memory/rebuild overhead isn't worth
+// it.
+type BruteForce struct {
+	metric Metric
+
+	mu    sync.RWMutex
+	items map[string][]float32
+}
+
+// NewBruteForce returns an empty BruteForce index scored by metric.
+func NewBruteForce(metric Metric) *BruteForce {
+	return &BruteForce{metric: metric, items: make(map[string][]float32)}
+}
+
+// Add implements Index.
+func (b *BruteForce) Add(items ...Item) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, item := range items {
+		b.items[item.ID] = item.Vector
+	}
+}
+
+// Remove implements Index.
+func (b *BruteForce) Remove(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.items, id)
+}
+
+// Len implements Index.
+func (b *BruteForce) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.items)
+}
+
+// Search implements Index by scoring every indexed vector and keeping a
+// bounded top-k heap, for O(n log k) instead of O(n log n).
+func (b *BruteForce) Search(query []float32, k int) []Result {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if k <= 0 {
+		return nil
+	}
+
+	top := &resultHeap{metric: b.metric}
+	for id, vector := range b.items {
+		score := b.metric.score(query, vector)
+		if b.metric.worseThanAll(top.items, k, score) {
+			continue
+		}
+		if len(top.items) < k {
+			heap.Push(top, Result{ID: id, Score: score})
+		} else {
+			top.items[0] = Result{ID: id, Score: score}
+			heap.Fix(top, 0)
+		}
+	}
+
+	return top.sortedBestFirst()
+}
+
+// resultHeap is a min-heap ordered worst-first (for MetricCosine, the
+// lowest similarity sits at the root; for MetricL2, the largest
+// distance does), so a full top-k can reject new candidates in O(log k)
+// and evict its current worst entry just as cheaply.
+type resultHeap struct {
+	items  []Result
+	metric Metric
+}
+
+func (h *resultHeap) Len() int      { return len(h.items) }
+func (h *resultHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *resultHeap) Less(i, j int) bool {
+	// The worse-ranked element sorts first, so it sits at the heap root
+	// and is the one Search evicts when a better candidate shows up.
+	return h.metric.better(h.items[j].Score, h.items[i].Score)
+}
+func (h *resultHeap) Push(x any) { h.items = append(h.items, x.(Result)) }
+func (h *resultHeap) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+func (h *resultHeap) sortedBestFirst() []Result {
+	out := make([]Result, len(h.items))
+	copy(out, h.items)
+	sort.Slice(out, func(i, j int) bool { return h.metric.better(out[i].Score, out[j].Score) })
+	return out
+}
@@ -0,0 +1,376 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package embeddingindex
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultM is the default number of bidirectional links created per
+	// node at every layer above 0.
+	DefaultM = 12
+	// DefaultEfConstruction is the default candidate-list size used
+	// while inserting a node.
+	DefaultEfConstruction = 100
+	// DefaultEfSearch is the default candidate-list size used while
+	// searching, trading recall for latency.
+	DefaultEfSearch = 48
+)
+
+// HNSWConfig controls the approximation/recall tradeoff of an HNSW
+// index. It's configurable per embedding kind, since a kind with a
+// handful of embeddings gets little from a graph and one with hundreds
+// of thousands needs it tuned for recall.
+type HNSWConfig struct {
+	// M is the number of bidirectional links per node at every layer
+	// above 0 (layer 0 uses 2*M). Higher M improves recall at the cost
+	// of memory and insert time.
+	M int
+	// EfConstruction is the candidate-list size used while inserting a
+	// node; higher values improve graph quality at the cost of slower
+	// inserts.
+	EfConstruction int
+	// EfSearch is the candidate-list size used while searching; higher
+	// values improve recall at the cost of slower queries.
+	EfSearch int
+}
+
+func (c *HNSWConfig) setDefaults() {
+	if c.M == 0 {
+		c.M = DefaultM
+	}
+	if c.EfConstruction == 0 {
+		c.EfConstruction = DefaultEfConstruction
+	}
+	if c.EfSearch == 0 {
+		c.EfSearch = DefaultEfSearch
+	}
+}
+
+type hnswNode struct {
+	id        string
+	vector    []float32
+	neighbors [][]string // neighbors[level] = neighbor IDs at that level
+}
+
+// HNSW is an in-memory Hierarchical Navigable Small World graph index,
+// giving approximate nearest-neighbor search in roughly O(log n) time
+// instead of BruteForce's O(n), at the cost of occasionally missing the
+// true nearest neighbors. services/local's Embeddings builds it from the
+// backend the first time a kind is queried, not at startup, and keeps it
+// warm by calling Add on every subsequent Upsert.
+type HNSW struct {
+	metric Metric
+	cfg    HNSWConfig
+
+	mu      sync.RWMutex
+	nodes   map[string]*hnswNode
+	entry   string
+	topNorm float64 // 1/ln(M), used to sample each node's top layer
+	rng     *rand.Rand
+}
+
+// NewHNSW returns an empty HNSW index scored by metric and tuned by cfg
+// (zero fields fall back to the Default* constants).
+func NewHNSW(metric Metric, cfg HNSWConfig) *HNSW {
+	cfg.setDefaults()
+	return &HNSW{
+		metric:  metric,
+		cfg:     cfg,
+		nodes:   make(map[string]*hnswNode),
+		topNorm: 1 / math.Log(float64(cfg.M)),
+		rng:     rand.New(rand.NewSource(1)),
+	}
+}
+
+// Len implements Index.
+func (h *HNSW) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.nodes)
+}
+
+// Add implements Index, inserting each item into the graph. An item whose
+// ID is already present only has its vector replaced; its links are kept.
+func (h *HNSW) Add(items ...Item) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, item := range items {
+		h.insertLocked(item)
+	}
+}
+
+// Remove implements Index. The node is removed from the neighbor lists
+// of the nodes it links to; links to it held by any other node stay in
+// place and are skipped by searches. A new entry point is chosen if
+// necessary.
+func (h *HNSW) Remove(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node, ok := h.nodes[id]
+	if !ok {
+		return
+	}
+	for level, neighbors := range node.neighbors {
+		for _, neighborID := range neighbors {
+			neighbor := h.nodes[neighborID]
+			if neighbor == nil {
+				continue
+			}
+			neighbor.neighbors[level] = removeID(neighbor.neighbors[level], id)
+		}
+	}
+	delete(h.nodes, id)
+
+	if h.entry == id {
+		h.entry = ""
+		for otherID := range h.nodes {
+			h.entry = otherID
+			break
+		}
+	}
+}
+
+func removeID(ids []string, target string) []string {
+	for i, id := range ids {
+		if id == target {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+func (h *HNSW) insertLocked(item Item) {
+	if existing, ok := h.nodes[item.ID]; ok {
+		existing.vector = item.Vector
+		return
+	}
+
+	level := int(math.Floor(-math.Log(h.rng.Float64()) * h.topNorm))
+	node := &hnswNode{id: item.ID, vector: item.Vector, neighbors: make([][]string, level+1)}
+	h.nodes[item.ID] = node
+
+	if h.entry == "" {
+		h.entry = item.ID
+		return
+	}
+
+	entryPoint := h.entry
+	entryLevel := len(h.nodes[entryPoint].neighbors) - 1
+
+	// Descend greedily from the top layer down to one above the new
+	// node's level, always moving to the closest neighbor found.
+	for l := entryLevel; l > level; l-- {
+		entryPoint = h.greedyClosest(entryPoint, item.Vector, l)
+	}
+
+	// From min(entryLevel, level) down to 0, gather efConstruction
+	// candidates and link the new node to its best M of them.
+	for l := min(entryLevel, level); l >= 0; l-- {
+		candidates := h.searchLayer(entryPoint, item.Vector, h.cfg.EfConstruction, l)
+		maxNeighbors := h.cfg.M
+		if l == 0 {
+			maxNeighbors = h.cfg.M * 2
+		}
+
+		selected := selectNeighbors(candidates, maxNeighbors, h.metric)
+		node.neighbors[l] = idsOf(selected)
+
+		for _, candidate := range selected {
+			neighbor := h.nodes[candidate.ID]
+			neighbor.neighbors[l] = append(neighbor.neighbors[l], item.ID)
+			if len(neighbor.neighbors[l]) > maxNeighbors {
+				trimmed := h.rescoreNeighbors(neighbor, l)
+				neighbor.neighbors[l] = idsOf(selectNeighbors(trimmed, maxNeighbors, h.metric))
+			}
+		}
+
+		if len(candidates) > 0 {
+			entryPoint = candidates[0].ID
+		}
+	}
+
+	if level > entryLevel {
+		h.entry = item.ID
+	}
+}
+
+func (h *HNSW) rescoreNeighbors(node *hnswNode, level int) []Result {
+	out := make([]Result, 0, len(node.neighbors[level]))
+	for _, id := range node.neighbors[level] {
+		other := h.nodes[id]
+		if other == nil {
+			continue
+		}
+		out = append(out, Result{ID: id, Score: h.metric.score(node.vector, other.vector)})
+	}
+	return out
+}
+
+// greedyClosest walks from start towards the single closest neighbor to
+// query at level, stopping once no neighbor improves on the current
+// node. It's used to descend through the upper layers fast, where only
+// an approximate entry point into the next layer down is needed.
+func (h *HNSW) greedyClosest(start string, query []float32, level int) string {
+	current := start
+	currentScore := h.metric.score(query, h.nodes[current].vector)
+
+	for {
+		improved := false
+		for _, neighborID := range h.nodes[current].neighbors[level] {
+			neighbor := h.nodes[neighborID]
+			if neighbor == nil {
+				continue
+			}
+			score := h.metric.score(query, neighbor.vector)
+			if h.metric.better(score, currentScore) {
+				current, currentScore = neighborID, score
+				improved = true
+			}
+		}
+		if !improved {
+			return current
+		}
+	}
+}
+
+// searchLayer performs a best-first search for up to ef candidates
+// nearest to query at level, starting from entryPoint. Results are
+// sorted best-first.
+//
+// toExplore is a min-heap ordered so the most promising unexplored
+// candidate pops first; found is the bounded worst-first top-ef heap
+// also used by BruteForce, so a new candidate can be compared against
+// the current worst in O(1) and folded in in O(log ef). Using heaps
+// instead of re-sorting each slice on every iteration is what keeps a
+// single search close to O(ef log ef) instead of O(visited * ef log ef).
+func (h *HNSW) searchLayer(entryPoint string, query []float32, ef int, level int) []Result {
+	visited := map[string]struct{}{entryPoint: {}}
+	entryScore := h.metric.score(query, h.nodes[entryPoint].vector)
+
+	toExplore := &exploreHeap{metric: h.metric}
+	heap.Push(toExplore, Result{ID: entryPoint, Score: entryScore})
+
+	found := &resultHeap{metric: h.metric}
+	heap.Push(found, Result{ID: entryPoint, Score: entryScore})
+
+	for toExplore.Len() > 0 {
+		best := heap.Pop(toExplore).(Result)
+
+		if found.Len() >= ef && h.metric.better(found.items[0].Score, best.Score) {
+			break
+		}
+
+		for _, neighborID := range h.nodes[best.ID].neighbors[level] {
+			if _, ok := visited[neighborID]; ok {
+				continue
+			}
+			visited[neighborID] = struct{}{}
+
+			neighbor := h.nodes[neighborID]
+			if neighbor == nil {
+				continue
+			}
+			score := h.metric.score(query, neighbor.vector)
+
+			heap.Push(toExplore, Result{ID: neighborID, Score: score})
+			switch {
+			case found.Len() < ef:
+				heap.Push(found, Result{ID: neighborID, Score: score})
+			case h.metric.better(score, found.items[0].Score):
+				found.items[0] = Result{ID: neighborID, Score: score}
+				heap.Fix(found, 0)
+			}
+		}
+	}
+
+	return found.sortedBestFirst()
+}
+
+// exploreHeap is a min-heap ordered best-first, used by searchLayer to
+// always expand the most promising unvisited candidate next.
+type exploreHeap struct {
+	items  []Result
+	metric Metric
+}
+
+func (h *exploreHeap) Len() int      { return len(h.items) }
+func (h *exploreHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *exploreHeap) Less(i, j int) bool {
+	return h.metric.better(h.items[i].Score, h.items[j].Score)
+}
+func (h *exploreHeap) Push(x any) { h.items = append(h.items, x.(Result)) }
+func (h *exploreHeap) Pop() any {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+// selectNeighbors keeps the best max candidates from candidates (which
+// need not be sorted), best-first.
+func selectNeighbors(candidates []Result, max int, metric Metric) []Result {
+	sorted := make([]Result, len(candidates))
+	copy(sorted, candidates)
+	sort.Slice(sorted, func(i, j int) bool { return metric.better(sorted[i].Score, sorted[j].Score) })
+	if len(sorted) > max {
+		sorted = sorted[:max]
+	}
+	return sorted
+}
+
+func idsOf(results []Result) []string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+// Search implements Index.
+func (h *HNSW) Search(query []float32, k int) []Result {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.entry == "" || k <= 0 {
+		return nil
+	}
+
+	entryPoint := h.entry
+	topLevel := len(h.nodes[entryPoint].neighbors) - 1
+	for l := topLevel; l > 0; l-- {
+		entryPoint = h.greedyClosest(entryPoint, query, l)
+	}
+
+	ef := h.cfg.EfSearch
+	if ef < k {
+		ef = k
+	}
+	results := h.searchLayer(entryPoint, query, ef, 0)
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
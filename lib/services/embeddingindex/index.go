@@ -0,0 +1,119 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package embeddingindex provides pluggable approximate- and exact-
+// nearest-neighbor indexes over embedding vectors, so that
+// services.Embeddings.QuerySimilar doesn't have to stream every
+// embedding of a kind out of the backend and score it in memory on
+// every call.
+//
+// An Index is kept warm in process memory: services/local's Embeddings
+// implementation builds one from the backend the first time a kind is
+// queried, not at startup, and calls Add again on every subsequent
+// Upsert, so QuerySimilar scores candidates in memory and reads from the
+// backend only the embeddings it returns.
+package embeddingindex
+
+import "math"
+
+// Item is a single vector tracked by an Index, keyed by the same
+// resourceID used to fetch the full embedding back out of the backend.
+type Item struct {
+	ID     string
+	Vector []float32
+}
+
+// Result is one hit returned by Index.Search, ordered best-first
+// according to the Index's Metric.
+type Result struct {
+	ID string
+	// Score is the raw distance/similarity value for Metric - higher is
+	// closer for MetricCosine, lower is closer for MetricL2.
+	Score float32
+}
+
+// Metric selects the distance function an Index scores vectors with.
+type Metric int
+
+const (
+	// MetricCosine scores by cosine similarity; higher Results are closer.
+	MetricCosine Metric = iota
+	// MetricL2 scores by squared Euclidean distance; lower Results are closer.
+	MetricL2
+)
+
+// Index is a pluggable nearest-neighbor search over a fixed-dimension
+// vector space. Implementations are not safe for concurrent use unless
+// documented otherwise.
+type Index interface {
+	// Add inserts or updates items in the index.
+	Add(items ...Item)
+	// Remove drops id from the index, if present.
+	Remove(id string)
+	// Search returns up to k Items nearest to query, best-first.
+	Search(query []float32, k int) []Result
+	// Len returns the number of items currently indexed.
+	Len() int
+}
+
+func cosineSimilarity(a, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
+
+func squaredL2(a, b []float32) float32 {
+	var sum float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+	return float32(sum)
+}
+
+// score returns the similarity/distance between a and b for m.
+func (m Metric) score(a, b []float32) float32 {
+	if m == MetricCosine {
+		return cosineSimilarity(a, b)
+	}
+	return squaredL2(a, b)
+}
+
+// better reports whether score a is better-ranked than score b for this
+// metric (used by the index implementations to keep a top-k heap without
+// hard-coding "higher/lower is better" at each call site).
+func (m Metric) better(a, b float32) bool {
+	if m == MetricCosine {
+		return a > b
+	}
+	return a < b
+}
+
+// worseThanAll reports whether score added to a results slice (ordered
+// worst-first, capacity k) would be dropped, i.e. the slice is already
+// full of candidates at least as good.
+func (m Metric) worseThanAll(results []Result, k int, score float32) bool {
+	return len(results) >= k && !m.better(score, results[0].Score)
+}
@@ -0,0 +1,130 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package embeddingindex
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func randomVector(rng *rand.Rand, dim int) []float32 {
+	v := make([]float32, dim)
+	for i := range v {
+		v[i] = rng.Float32()*2 - 1
+	}
+	return v
+}
+
+func randomItems(seed int64, n, dim int) []Item {
+	rng := rand.New(rand.NewSource(seed))
+	items := make([]Item, n)
+	for i := range items {
+		items[i] = Item{ID: fmt.Sprintf("item-%d", i), Vector: randomVector(rng, dim)}
+	}
+	return items
+}
+
+func TestBruteForceFindsExactNearestNeighbor(t *testing.T) {
+	index := NewBruteForce(MetricCosine)
+	items := randomItems(1, 200, 16)
+	index.Add(items...)
+	require.Equal(t, 200, index.Len())
+
+	query := items[42].Vector
+	results := index.Search(query, 1)
+	require.Len(t, results, 1)
+	require.Equal(t, "item-42", results[0].ID)
+}
+
+func TestBruteForceL2PrefersClosestPoint(t *testing.T) {
+	index := NewBruteForce(MetricL2)
+	index.Add(
+		Item{ID: "near", Vector: []float32{1, 1}},
+		Item{ID: "far", Vector: []float32{10, 10}},
+	)
+
+	results := index.Search([]float32{1, 2}, 2)
+	require.Len(t, results, 2)
+	require.Equal(t, "near", results[0].ID)
+	require.Equal(t, "far", results[1].ID)
+	require.Less(t, results[0].Score, results[1].Score)
+}
+
+func TestBruteForceRemove(t *testing.T) {
+	index := NewBruteForce(MetricCosine)
+	index.Add(Item{ID: "a", Vector: []float32{1, 0}}, Item{ID: "b", Vector: []float32{0, 1}})
+	index.Remove("a")
+	require.Equal(t, 1, index.Len())
+
+	results := index.Search([]float32{1, 0}, 5)
+	require.Len(t, results, 1)
+	require.Equal(t, "b", results[0].ID)
+}
+
+func TestHNSWLenAndRemove(t *testing.T) {
+	index := NewHNSW(MetricCosine, HNSWConfig{})
+	index.Add(randomItems(2, 50, 8)...)
+	require.Equal(t, 50, index.Len())
+
+	index.Remove("item-0")
+	require.Equal(t, 49, index.Len())
+	for _, result := range index.Search(randomVector(rand.New(rand.NewSource(3)), 8), 49) {
+		require.NotEqual(t, "item-0", result.ID)
+	}
+}
+
+func TestHNSWRecallAgainstBruteForce(t *testing.T) {
+	const n, dim, k = 2000, 24, 10
+	items := randomItems(42, n, dim)
+
+	brute := NewBruteForce(MetricCosine)
+	brute.Add(items...)
+
+	hnsw := NewHNSW(MetricCosine, HNSWConfig{M: 16, EfConstruction: 200, EfSearch: 128})
+	hnsw.Add(items...)
+
+	rng := rand.New(rand.NewSource(99))
+	const queries = 25
+	var hits int
+	for i := 0; i < queries; i++ {
+		query := randomVector(rng, dim)
+
+		want := brute.Search(query, k)
+		got := hnsw.Search(query, k)
+		require.LessOrEqual(t, len(got), k)
+
+		wantIDs := make(map[string]struct{}, len(want))
+		for _, r := range want {
+			wantIDs[r.ID] = struct{}{}
+		}
+		for _, r := range got {
+			if _, ok := wantIDs[r.ID]; ok {
+				hits++
+			}
+		}
+	}
+
+	// HNSW is approximate; require it to recover most, not necessarily
+	// all, of brute force's true top-k across the query batch.
+	recall := float64(hits) / float64(queries*k)
+	require.Greaterf(t, recall, 0.8, "recall was only %.2f", recall)
+}
@@ -1,17 +1,19 @@
 /*
- * Copyright 2023 Gravitational, Inc.
+ * Teleport
+ * Copyright (C) 2023  Gravitational, Inc.
  *
- * Licensed under the Apache License, Version 2.0 (the "License");
- * you may not use this file except in compliance with the License.
- * You may obtain a copy of the License at
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
  *
- *     http://www.apache.org/licenses/LICENSE-2.0
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
  *
- * Unless required by applicable law or agreed to in writing, software
- * distributed under the License is distributed on an "AS IS" BASIS,
- * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
- * See the License for the specific language governing permissions and
- * limitations under the License.
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
 package services
@@ -23,6 +25,26 @@ import (
 	"github.com/gravitational/teleport/api/internalutils/stream"
 )
 
+// QueryFilter narrows a QuerySimilar search to embeddings whose resource
+// carries every one of the given labels. A nil or empty QueryFilter
+// matches every embedding of the queried kind.
+type QueryFilter struct {
+	// Labels restricts results to embeddings whose resource has all of
+	// these label key/value pairs.
+	Labels map[string]string
+}
+
+// ScoredEmbedding pairs an Embedding with its distance from the query
+// vector in a QuerySimilar call, so callers can rank or threshold
+// results without recomputing the score themselves.
+type ScoredEmbedding struct {
+	*embeddingpb.Embedding
+	// Distance is the similarity score for the metric of the index
+	// backing QuerySimilar: higher is closer for cosine similarity, lower is
+	// closer for L2 distance.
+	Distance float32
+}
+
 // Embeddings service is responsible for storing and retrieving embeddings in
 // the backend. The backend acts as an embedding cache. Embeddings can be
 // re-generated by an ai.Embedder.
@@ -33,4 +55,14 @@ type Embeddings interface {
 	GetEmbeddings(ctx context.Context, kind string) stream.Stream[*embeddingpb.Embedding]
 	// UpsertEmbedding creates or updates a single ai.Embedding in the backend.
 	UpsertEmbedding(ctx context.Context, embedding *embeddingpb.Embedding) (*embeddingpb.Embedding, error)
+	// UpsertEmbeddings creates or updates a batch of embeddings in a
+	// single call, so callers backfilling or re-embedding many resources
+	// don't pay a round trip per embedding.
+	UpsertEmbeddings(ctx context.Context, batch []*embeddingpb.Embedding) error
+	// QuerySimilar returns the k embeddings of the given kind nearest to
+	// query, most similar first, optionally narrowed by filter. It is
+	// backed by a pluggable embeddingindex.Index kept warm in memory, so
+	// it doesn't require streaming every embedding of kind out of the
+	// backend the way scoring GetEmbeddings results in memory would.
+	QuerySimilar(ctx context.Context, kind string, query []float32, k int, filter *QueryFilter) ([]ScoredEmbedding, error)
 }
@@ -0,0 +1,303 @@
+/*
+ * Teleport
+ * Copyright (C) 2023  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"sort"
+	"sync"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/services"
+)
+
+// RootCABundle is the PEM-encoded trust anchor bundle for a CA type: the
+// active CA's certificates, any certificates staged for an in-progress
+// rotation, and additional trusted-cluster CAs, deduplicated and
+// concatenated in a stable order. It mirrors the shape of swarmkit's
+// NodeCertificateStatusResponse.RootCABundle - something nodes and
+// clients can embed wholesale when bootstrapping trust.
+type RootCABundle struct {
+	// PEM is the concatenated, deduplicated trust anchors.
+	PEM []byte
+	// Version is a hash of the sorted DER contents of every certificate
+	// in the bundle, so that clients can cheaply detect when the bundle
+	// has changed and pin trust across CA rotations.
+	Version string
+}
+
+// GetRootCABundle concatenates the PEM-encoded trust anchors for the
+// active CA of the given type plus any additional trusted-cluster CAs
+// and rotation-staged keys, deduplicating identical certificates across
+// active and inactive keys.
+func (s *CA) GetRootCABundle(ctx context.Context, caType types.CertAuthType) (*RootCABundle, error) {
+	cas, err := s.collectTrustedCAs(ctx, caType)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return buildRootCABundle(cas), nil
+}
+
+// collectTrustedCAs gathers every CertAuthority of caType that
+// contributes to the trust bundle: the active CAs and the deactivated
+// (rotation-staged) CAs.
+func (s *CA) collectTrustedCAs(ctx context.Context, caType types.CertAuthType) ([]types.CertAuthority, error) {
+	active, err := s.GetCertAuthorities(ctx, caType, false)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	inactive, err := s.getInactiveCertAuthorities(ctx, caType, active)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return append(active, inactive...), nil
+}
+
+// getInactiveCertAuthorities returns, for each active CertAuthority of
+// caType, its deactivated (rotation-staged) counterpart, if one exists,
+// so its still-valid trust anchors keep being honored until the
+// rotation completes.
+func (s *CA) getInactiveCertAuthorities(ctx context.Context, caType types.CertAuthType, active []types.CertAuthority) ([]types.CertAuthority, error) {
+	var inactive []types.CertAuthority
+	for _, ca := range active {
+		id := types.CertAuthID{Type: caType, DomainName: ca.GetName()}
+		item, err := s.Get(ctx, inactiveKey(id))
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return nil, trace.Wrap(err)
+		}
+		value, err := s.compressor.decode(item.Value)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		deactivated, err := services.UnmarshalCertAuthority(value)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		inactive = append(inactive, deactivated)
+	}
+	return inactive, nil
+}
+
+// buildRootCABundle concatenates the PEM-encoded TLS certificates of the
+// supplied CAs, deduplicating identical certificates and producing a
+// version identifier from the sorted DER contents.
+func buildRootCABundle(cas []types.CertAuthority) *RootCABundle {
+	var certs [][]byte
+	for _, ca := range cas {
+		for _, kp := range ca.GetTrustedTLSKeyPairs() {
+			if len(kp.Cert) == 0 {
+				continue
+			}
+			certs = append(certs, kp.Cert)
+		}
+	}
+	return bundlePEMCerts(certs)
+}
+
+// bundlePEMCerts deduplicates certs (each already PEM-encoded, as stored
+// in a TrustedTLSKeyPair's Cert field) and concatenates them in a stable
+// order, hashing the DER contents for the bundle's Version.
+func bundlePEMCerts(certs [][]byte) *RootCABundle {
+	// certs are already PEM, so they're deduplicated and concatenated
+	// as-is rather than being re-wrapped in another CERTIFICATE block,
+	// which would PEM-encode PEM text instead of DER.
+	seen := make(map[string][]byte, len(certs))
+	for _, cert := range certs {
+		seen[string(cert)] = cert
+	}
+
+	unique := make([]string, 0, len(seen))
+	ders := make(map[string][]byte, len(seen))
+	for raw := range seen {
+		unique = append(unique, raw)
+		if block, _ := pem.Decode([]byte(raw)); block != nil {
+			ders[raw] = block.Bytes
+		}
+	}
+	// Sort by DER so the order, and so the version hash, doesn't depend
+	// on PEM text; the PEM text only breaks ties.
+	sort.Slice(unique, func(i, j int) bool {
+		if c := bytes.Compare(ders[unique[i]], ders[unique[j]]); c != 0 {
+			return c < 0
+		}
+		return unique[i] < unique[j]
+	})
+
+	var pemBundle []byte
+	hash := sha256.New()
+	for _, certPEM := range unique {
+		pemBundle = append(pemBundle, certPEM...)
+
+		block, _ := pem.Decode([]byte(certPEM))
+		if block == nil {
+			// The signing path that produced this certificate already
+			// validated it's well-formed PEM; this would mean on-disk
+			// corruption rather than malformed input, so it's excluded
+			// from the version hash rather than failing the whole bundle.
+			continue
+		}
+		hash.Write(block.Bytes)
+	}
+
+	return &RootCABundle{
+		PEM:     pemBundle,
+		Version: hex.EncodeToString(hash.Sum(nil)),
+	}
+}
+
+// RootCABundleWatcher emits a new RootCABundle whenever the set of CAs
+// backing it changes.
+type RootCABundleWatcher struct {
+	ca     *CA
+	caType types.CertAuthType
+	ch     chan *RootCABundle
+	dirty  chan struct{}
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Bundles returns the channel of updated bundles. The first value is
+// sent immediately with the bundle's current contents.
+func (w *RootCABundleWatcher) Bundles() <-chan *RootCABundle {
+	return w.ch
+}
+
+// Close stops the watcher and releases its resources.
+func (w *RootCABundleWatcher) Close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.ca.removeRootCABundleWatcher(w)
+	})
+}
+
+// run serializes this watcher's bundle recomputation: every mutation
+// signals dirty, and this single goroutine drains it and recomputes one
+// bundle at a time. Without that serialization, two near-simultaneous
+// mutations each spawning their own notify() call could finish in either
+// order and leave the subscriber pinned to a stale bundle - the opposite
+// of the "clients can pin trust across rotations" guarantee.
+func (w *RootCABundleWatcher) run() {
+	for {
+		select {
+		case <-w.dirty:
+			w.notify(context.Background())
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// markDirty queues a recompute. If one is already queued, this is a
+// no-op: that pending recompute will read the latest state (GetRootCABundle
+// always reflects current backend contents) once run picks it up, so no
+// mutation is ever lost.
+func (w *RootCABundleWatcher) markDirty() {
+	select {
+	case w.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (w *RootCABundleWatcher) notify(ctx context.Context) {
+	bundle, err := w.ca.GetRootCABundle(ctx, w.caType)
+	if err != nil {
+		return
+	}
+	select {
+	case w.ch <- bundle:
+	case <-w.done:
+	default:
+		// Drop the stale value sitting in the buffered channel and
+		// replace it with the latest one; only the newest bundle
+		// matters to a subscriber that hasn't kept up.
+		select {
+		case <-w.ch:
+		default:
+		}
+		select {
+		case w.ch <- bundle:
+		case <-w.done:
+		}
+	}
+}
+
+// WatchRootCABundle returns a RootCABundleWatcher that emits a new
+// bundle whenever CreateCertAuthorities, UpsertCertAuthority,
+// ActivateCertAuthority, or DeactivateCertAuthority mutates the set of
+// CertAuthorities of the given type. The returned bundle is deduplicated
+// and versioned the same way as GetRootCABundle.
+func (s *CA) WatchRootCABundle(ctx context.Context, caType types.CertAuthType) (*RootCABundleWatcher, error) {
+	bundle, err := s.GetRootCABundle(ctx, caType)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	w := &RootCABundleWatcher{
+		ca:     s,
+		caType: caType,
+		ch:     make(chan *RootCABundle, 1),
+		dirty:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	w.ch <- bundle
+	go w.run()
+
+	s.bundleMu.Lock()
+	s.bundleWatchers[caType] = append(s.bundleWatchers[caType], w)
+	s.bundleMu.Unlock()
+
+	return w, nil
+}
+
+func (s *CA) removeRootCABundleWatcher(w *RootCABundleWatcher) {
+	s.bundleMu.Lock()
+	defer s.bundleMu.Unlock()
+	watchers := s.bundleWatchers[w.caType]
+	for i, existing := range watchers {
+		if existing == w {
+			s.bundleWatchers[w.caType] = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+}
+
+// notifyRootCABundleWatchers recomputes and pushes the root CA bundle to
+// every watcher registered for caType. It is called from the
+// CertAuthority mutation methods that can change the trust bundle.
+func (s *CA) notifyRootCABundleWatchers(caType types.CertAuthType) {
+	s.bundleMu.Lock()
+	watchers := append([]*RootCABundleWatcher(nil), s.bundleWatchers[caType]...)
+	s.bundleMu.Unlock()
+
+	for _, w := range watchers {
+		w.markDirty()
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// selfSignedCertPEM returns a minimal self-signed certificate, PEM-encoded,
+// distinguished from others by commonName.
+func selfSignedCertPEM(t *testing.T, commonName string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestBundlePEMCertsDoesNotDoublePEMEncode(t *testing.T) {
+	certPEM := selfSignedCertPEM(t, "root-1")
+
+	bundle := bundlePEMCerts([][]byte{certPEM})
+
+	// A double-encoded bundle would wrap certPEM's own PEM text in another
+	// CERTIFICATE block, so decoding once would leave PEM headers in the
+	// remainder instead of consuming the whole input.
+	block, rest := pem.Decode(bundle.PEM)
+	require.NotNil(t, block)
+	require.Empty(t, rest)
+
+	wantBlock, _ := pem.Decode(certPEM)
+	require.Equal(t, wantBlock.Bytes, block.Bytes)
+}
+
+func TestBundlePEMCertsDeduplicatesAndSorts(t *testing.T) {
+	a := selfSignedCertPEM(t, "a")
+	b := selfSignedCertPEM(t, "b")
+
+	bundle := bundlePEMCerts([][]byte{b, a, a, b})
+
+	var blocks [][]byte
+	rest := bundle.PEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block.Bytes)
+	}
+	require.Empty(t, rest)
+	require.Len(t, blocks, 2, "duplicate certificates must be deduplicated")
+	require.Negative(t, bytes.Compare(blocks[0], blocks[1]), "certificates must be sorted by DER")
+}
+
+func TestBundlePEMCertsVersionHashesDERNotPEM(t *testing.T) {
+	certPEM := selfSignedCertPEM(t, "root-1")
+	block, _ := pem.Decode(certPEM)
+
+	bundle := bundlePEMCerts([][]byte{certPEM})
+
+	wantHash := sha256.Sum256(block.Bytes)
+	require.Equal(t, hex.EncodeToString(wantHash[:]), bundle.Version)
+
+	// Re-PEM-encoding the same DER with different line wrapping/headers
+	// must not change the version: it's a hash of DER, not of the PEM
+	// text, which can vary without the certificate changing.
+	reEncoded := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes, Headers: map[string]string{"X-Note": "re-encoded"}})
+	reBundle := bundlePEMCerts([][]byte{reEncoded})
+	require.Equal(t, bundle.Version, reBundle.Version)
+}
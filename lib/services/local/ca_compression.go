@@ -0,0 +1,108 @@
+/*
+ * Teleport
+ * Copyright (C) 2023  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/gravitational/trace"
+)
+
+// caValueMagic prefixes a CertAuthority backend.Item.Value to identify its
+// encoding. Items written before compression support was added have no
+// prefix at all, so caValueUncompressed must never collide with the first
+// byte of a valid CertAuthorityV2 JSON document (always '{' == 0x7b).
+type caValueMagic byte
+
+const (
+	// caValueUncompressed is never written: values stored uncompressed,
+	// like legacy items that predate the magic-byte prefix, carry no
+	// prefix and start with '{', and decode returns them unchanged.
+	caValueUncompressed caValueMagic = 0x00
+	// caValueGzip marks a value whose remainder is a gzip-compressed
+	// CertAuthorityV2 document.
+	caValueGzip caValueMagic = 0x1f // matches gzip's own magic number, kept distinct from '{'
+)
+
+// defaultCACompressionThreshold is the minimum marshaled size, in bytes,
+// before a CertAuthority value is compressed. Small CAs (no rotation in
+// progress, a single active keypair) gain little from gzip and paying the
+// CPU cost on every read is wasted, so only large, rotated-key payloads
+// are worth compressing.
+const defaultCACompressionThreshold = 8 * 1024
+
+// caCompressor encodes and decodes the at-rest representation of a
+// CertAuthority value, transparently gzip-compressing values above a
+// configurable threshold while remaining able to read back uncompressed
+// items written before this feature existed.
+type caCompressor struct {
+	// enabled opts a cluster in to writing compressed values. Reads
+	// always understand both encodings regardless of this flag, so
+	// operators can turn it on and off without a migration.
+	enabled   bool
+	threshold int
+}
+
+func newCACompressor(enabled bool) caCompressor {
+	return caCompressor{enabled: enabled, threshold: defaultCACompressionThreshold}
+}
+
+// encode returns the at-rest bytes for a marshaled CertAuthority value,
+// compressing it and prefixing the result with caValueGzip if compression
+// is enabled and the value is at least the configured threshold in size.
+func (c caCompressor) encode(value []byte) ([]byte, error) {
+	if !c.enabled || len(value) < c.threshold {
+		return value, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(caValueGzip))
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decode reverses encode. It recognizes the gzip magic-byte prefix and
+// decompresses accordingly; any value that does not start with that
+// prefix (including every item written before compression support
+// existed) is returned unchanged.
+func (c caCompressor) decode(value []byte) ([]byte, error) {
+	if len(value) == 0 || caValueMagic(value[0]) != caValueGzip {
+		return value, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(value[1:]))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer r.Close()
+
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,92 @@
+/*
+ * Teleport
+ * Copyright (C) 2023  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCACompressorRoundTrip(t *testing.T) {
+	small := bytes.Repeat([]byte("a"), 128)
+	large := bytes.Repeat([]byte("certificate-authority-payload"), 1024)
+
+	for _, enabled := range []bool{false, true} {
+		c := newCACompressor(enabled)
+
+		encodedSmall, err := c.encode(small)
+		require.NoError(t, err)
+		// Below the threshold, the value is always passed through
+		// unchanged regardless of whether compression is enabled.
+		require.Equal(t, small, encodedSmall)
+
+		encodedLarge, err := c.encode(large)
+		require.NoError(t, err)
+		if enabled {
+			require.Less(t, len(encodedLarge), len(large))
+		} else {
+			require.Equal(t, large, encodedLarge)
+		}
+
+		decodedLarge, err := c.decode(encodedLarge)
+		require.NoError(t, err)
+		require.Equal(t, large, decodedLarge)
+	}
+}
+
+func TestCACompressorReadsLegacyUncompressedItems(t *testing.T) {
+	legacy := []byte(`{"kind":"cert_authority"}`)
+	c := newCACompressor(true)
+
+	decoded, err := c.decode(legacy)
+	require.NoError(t, err)
+	require.Equal(t, legacy, decoded)
+}
+
+func BenchmarkCACompressorSmallCA(b *testing.B) {
+	c := newCACompressor(true)
+	value := bytes.Repeat([]byte("x"), 512)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.encode(value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCACompressorRotatedKeysCA(b *testing.B) {
+	c := newCACompressor(true)
+	// Roughly approximates a HostCA/UserCA with several rotated
+	// keypairs: about 56 KiB of mostly-repetitive PEM-encoded keys.
+	value := bytes.Repeat([]byte("-----BEGIN CERTIFICATE-----\n"), 2048)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoded, err := c.encode(value)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := c.decode(encoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
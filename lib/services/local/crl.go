@@ -0,0 +1,516 @@
+/*
+ * Teleport
+ * Copyright (C) 2023  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/teleport/lib/backend"
+)
+
+// crlBuilder periodically materializes complete and delta CRLs for the
+// CertAuthorities stored in the backend. It mirrors the split used by
+// Vault's PKI backend: the complete CRL is only rebuilt when forced (a
+// revocation happened or the signer rotated), while the delta CRL can be
+// rebuilt more eagerly, listing only the entries revoked since the last
+// complete CRL instead of re-signing the whole revoked set.
+type crlBuilder struct {
+	ca *CA
+
+	mu          sync.Mutex
+	forced      map[crlCacheKey]bool
+	deltaForced map[crlCacheKey]bool
+	// known is every CA id this builder has ever been asked to rebuild a
+	// CRL for, so reconcileStaleCRLs has something to sweep for
+	// near-expiry CRLs without needing to enumerate every CertAuthority
+	// in the backend on every tick.
+	known map[crlCacheKey]bool
+}
+
+type crlCacheKey struct {
+	caType     types.CertAuthType
+	domainName string
+}
+
+func newCRLBuilder(ca *CA) *crlBuilder {
+	return &crlBuilder{
+		ca:          ca,
+		forced:      make(map[crlCacheKey]bool),
+		deltaForced: make(map[crlCacheKey]bool),
+		known:       make(map[crlCacheKey]bool),
+	}
+}
+
+// invalidate marks the CRL for the given CA id as stale. The next tick of
+// the periodic rebuild goroutine regenerates both the complete and the
+// delta CRL for it.
+func (b *crlBuilder) invalidate(id types.CertAuthID) {
+	key := crlCacheKey{caType: id.Type, domainName: id.DomainName}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.forced[key] = true
+	b.deltaForced[key] = true
+	b.known[key] = true
+}
+
+// markForced re-queues key to be retried on the next tick. It's used
+// when a rebuild is skipped because another auth server currently holds
+// the rebuild lock, so the forced marker drained at the start of this
+// tick isn't lost.
+func (b *crlBuilder) markForced(key crlCacheKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.forced[key] = true
+	b.known[key] = true
+}
+
+// markDeltaForced is markForced's delta-CRL equivalent.
+func (b *crlBuilder) markDeltaForced(key crlCacheKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deltaForced[key] = true
+	b.known[key] = true
+}
+
+// reconcileStaleCRLs force-rebuilds any known CA's complete CRL that's
+// nearing its NextUpdate, regardless of whether a revocation has
+// happened recently. Without this, a CA with no revocation activity for
+// crlValidityPeriod has its last-built CRL expire from the backend (its
+// Item.Expires is set to NextUpdate) and GetCRL starts returning
+// NotFound for a perfectly valid, unrevoked CA.
+func (b *crlBuilder) reconcileStaleCRLs(ctx context.Context) error {
+	b.mu.Lock()
+	keys := make([]crlCacheKey, 0, len(b.known))
+	for key := range b.known {
+		keys = append(keys, key)
+	}
+	b.mu.Unlock()
+
+	for _, key := range keys {
+		id := types.CertAuthID{Type: key.caType, DomainName: key.domainName}
+		der, err := b.ca.GetCRL(ctx, id, false)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return trace.Wrap(err)
+		}
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if time.Until(crl.NextUpdate) <= crlReconcileMargin {
+			b.invalidate(id)
+		}
+	}
+	return nil
+}
+
+// rebuildIfForced rebuilds the complete CRL for every CA that was marked
+// stale since the last call. Non-fatal conditions (no signing key loaded,
+// an authority that no longer exists) are reported as warnings so that a
+// single bad CA does not stop the tick from processing the rest.
+func (b *crlBuilder) rebuildIfForced(sc context.Context) (warnings []string, err error) {
+	for _, key := range b.drain(b.forced) {
+		id := types.CertAuthID{Type: key.caType, DomainName: key.domainName}
+		warning, skipped, rebuildErr := b.ca.rebuildCRL(sc, id, false)
+		if rebuildErr != nil {
+			return warnings, trace.Wrap(rebuildErr)
+		}
+		if skipped {
+			// Another auth server is rebuilding this CRL right now.
+			// Retry on the next tick instead of losing the marker that
+			// was drained at the top of this one.
+			b.markForced(key)
+			continue
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings, nil
+}
+
+// rebuildDeltaCRLsIfForced rebuilds the delta CRL for every CA marked
+// stale since the last call, unless force is true in which case every CA
+// with a forced or deltaForced marker is rebuilt regardless of staleness.
+func (b *crlBuilder) rebuildDeltaCRLsIfForced(sc context.Context, force bool) (warnings []string, err error) {
+	keys := b.drain(b.deltaForced)
+	if force {
+		keys = append(keys, b.drain(b.forced)...)
+	}
+	for _, key := range keys {
+		id := types.CertAuthID{Type: key.caType, DomainName: key.domainName}
+		warning, skipped, rebuildErr := b.ca.rebuildCRL(sc, id, true)
+		if rebuildErr != nil {
+			return warnings, trace.Wrap(rebuildErr)
+		}
+		if skipped {
+			b.markDeltaForced(key)
+			continue
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings, nil
+}
+
+func (b *crlBuilder) drain(m map[crlCacheKey]bool) []crlCacheKey {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]crlCacheKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	clear(m)
+	return keys
+}
+
+// rebuildCRL regenerates the complete or delta CRL for the given CA and
+// stores the result under the crl backend prefix. Concurrent rebuilds of
+// the same CRL are suppressed with a short-lived lock item: skipped is
+// true when another auth server currently holds it, so the caller can
+// retry later instead of treating the rebuild as done.
+func (s *CA) rebuildCRL(ctx context.Context, id types.CertAuthID, delta bool) (warning string, skipped bool, err error) {
+	ca, err := s.GetCertAuthority(ctx, id, true)
+	if err != nil {
+		if trace.IsNotFound(err) {
+			return fmt.Sprintf("CRL rebuild skipped: CA %v/%v no longer exists", id.Type, id.DomainName), false, nil
+		}
+		return "", false, trace.Wrap(err)
+	}
+
+	signer, signerCert, err := caSigner(ca)
+	if err != nil {
+		return fmt.Sprintf("CRL rebuild skipped: CA %v/%v has no usable signing key: %v", id.Type, id.DomainName, err), false, nil
+	}
+
+	if !s.acquireCRLRebuildLock(ctx, id, delta) {
+		// Another auth server is already rebuilding this CRL.
+		return "", true, nil
+	}
+	// The lock only needs to cover this rebuild, not the rest of its
+	// safety-net TTL, so release it as soon as we're done: otherwise a
+	// revocation that forces another rebuild within the TTL window would
+	// find the lock still held and be skipped.
+	defer s.releaseCRLRebuildLock(ctx, id, delta)
+
+	entries, err := s.caRevokedCertEntries(ctx, id)
+	if err != nil {
+		return "", false, trace.Wrap(err)
+	}
+
+	now := time.Now().UTC()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(now.UnixNano()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(crlValidityPeriod),
+	}
+
+	if delta {
+		base, baseErr := s.baseCRLForDelta(ctx, id)
+		if baseErr != nil {
+			if trace.IsNotFound(baseErr) {
+				return fmt.Sprintf("delta CRL rebuild skipped: CA %v/%v has no complete CRL yet to delta against", id.Type, id.DomainName), false, nil
+			}
+			return "", false, trace.Wrap(baseErr)
+		}
+		ext, extErr := deltaCRLIndicatorExtension(base.Number)
+		if extErr != nil {
+			return "", false, trace.Wrap(extErr)
+		}
+		template.RevokedCertificateEntries = entriesRevokedSince(entries, base.ThisUpdate)
+		template.ExtraExtensions = []pkix.Extension{ext}
+	} else {
+		template.RevokedCertificateEntries = entries
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, signerCert, signer)
+	if err != nil {
+		return "", false, trace.Wrap(err)
+	}
+
+	_, err = s.Put(ctx, backend.Item{
+		Key:     crlKey(id, delta),
+		Value:   der,
+		Expires: now.Add(crlValidityPeriod),
+	})
+	return "", false, trace.Wrap(err)
+}
+
+// baseCRLForDelta fetches and parses the CA's current complete CRL, so a
+// delta CRL rebuild can list only the entries revoked since it and carry
+// its CRL number in the deltaCRLIndicator extension. It returns
+// trace.NotFound if no complete CRL has been built yet.
+func (s *CA) baseCRLForDelta(ctx context.Context, id types.CertAuthID) (*x509.RevocationList, error) {
+	der, err := s.GetCRL(ctx, id, false)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	base, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return base, nil
+}
+
+// entriesRevokedSince filters entries down to those revoked after
+// cutoff, the ThisUpdate of the complete CRL a delta CRL is based on.
+func entriesRevokedSince(entries []x509.RevocationListEntry, cutoff time.Time) []x509.RevocationListEntry {
+	since := make([]x509.RevocationListEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.RevocationTime.After(cutoff) {
+			since = append(since, entry)
+		}
+	}
+	return since
+}
+
+// deltaCRLIndicatorOID is the deltaCRLIndicator CRL extension's OID
+// (RFC 5280 section 5.2.4). Its value is the CRL number of the complete
+// CRL the delta is based on, marking the CRL as a delta rather than a
+// second complete CRL under a different label.
+var deltaCRLIndicatorOID = asn1.ObjectIdentifier{2, 5, 29, 27}
+
+// deltaCRLIndicatorExtension builds the deltaCRLIndicator extension
+// pointing at baseCRLNumber. RFC 5280 requires this extension be marked
+// critical, so a client that doesn't understand delta CRLs rejects it
+// instead of silently treating it as a complete CRL.
+func deltaCRLIndicatorExtension(baseCRLNumber *big.Int) (pkix.Extension, error) {
+	value, err := asn1.Marshal(baseCRLNumber)
+	if err != nil {
+		return pkix.Extension{}, trace.Wrap(err)
+	}
+	return pkix.Extension{
+		Id:       deltaCRLIndicatorOID,
+		Critical: true,
+		Value:    value,
+	}, nil
+}
+
+// caSigner returns the CA's active TLS signing key as a crypto.Signer and
+// its parsed certificate, ready to pass to x509.CreateRevocationList.
+func caSigner(ca types.CertAuthority) (crypto.Signer, *x509.Certificate, error) {
+	keyPairs := ca.GetActiveKeys().TLS
+	if len(keyPairs) == 0 {
+		return nil, nil, trace.NotFound("CA %v has no active TLS signing keys", ca.GetName())
+	}
+
+	tlsCert, err := tls.X509KeyPair(keyPairs[0].Cert, keyPairs[0].Key)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+
+	signer, ok := tlsCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, trace.BadParameter("CA %v signing key of type %T does not implement crypto.Signer", ca.GetName(), tlsCert.PrivateKey)
+	}
+
+	return signer, cert, nil
+}
+
+// acquireCRLRebuildLock returns true if the caller won the race to rebuild
+// this CRL. It uses a plain Create (fails if the lock item already
+// exists) so the lock both suppresses concurrent rebuilds and, through
+// its TTL, recovers automatically if the winner crashes before releasing
+// it via releaseCRLRebuildLock.
+func (s *CA) acquireCRLRebuildLock(ctx context.Context, id types.CertAuthID, delta bool) bool {
+	now := time.Now().UTC()
+	_, err := s.Create(ctx, backend.Item{
+		Key:     crlLockKey(id, delta),
+		Value:   []byte(now.Format(time.RFC3339Nano)),
+		Expires: now.Add(crlRebuildLockTTL),
+	})
+	return err == nil
+}
+
+// releaseCRLRebuildLock releases the lock acquired by acquireCRLRebuildLock
+// once a rebuild completes (successfully or not), so the next forced
+// rebuild isn't blocked until the lock's safety-net TTL expires.
+func (s *CA) releaseCRLRebuildLock(ctx context.Context, id types.CertAuthID, delta bool) {
+	if err := s.Delete(ctx, crlLockKey(id, delta)); err != nil && !trace.IsNotFound(err) {
+		s.log.Warnf("Failed to release CRL rebuild lock for %v/%v: %v", id.Type, id.DomainName, err)
+	}
+}
+
+// GetCRL returns the stored complete or delta CRL for the given CA id.
+func (s *CA) GetCRL(ctx context.Context, id types.CertAuthID, delta bool) ([]byte, error) {
+	item, err := s.Get(ctx, crlKey(id, delta))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return item.Value, nil
+}
+
+// RevokeCertificate records serial as revoked for the given CA, so the
+// next CRL rebuild includes it, and forces that rebuild.
+func (s *CA) RevokeCertificate(ctx context.Context, id types.CertAuthID, serial *big.Int, revokedAt time.Time) error {
+	record := revokedCertRecord{Serial: serial.Text(16), RevokedAt: revokedAt.UTC()}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	_, err = s.Put(ctx, backend.Item{
+		Key:   revokedCertKey(id, serial),
+		Value: value,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	s.crl.invalidate(id)
+	return nil
+}
+
+// caRevokedCertEntries lists the RevokedCertificateEntries for a CA's CRL
+// template by scanning its revoked-serial backend range. A CA with no
+// revoked certificates still produces a signed, empty CRL rather than
+// being treated as an error.
+func (s *CA) caRevokedCertEntries(ctx context.Context, id types.CertAuthID) ([]x509.RevocationListEntry, error) {
+	prefix := revokedCertPrefix(id)
+	result, err := s.GetRange(ctx, prefix, backend.RangeEnd(prefix), backend.NoLimit)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	entries := make([]x509.RevocationListEntry, 0, len(result.Items))
+	for _, item := range result.Items {
+		var record revokedCertRecord
+		if err := json.Unmarshal(item.Value, &record); err != nil {
+			s.log.Warnf("Failed to decode revoked certificate record at %q: %v", item.Key, err)
+			continue
+		}
+		serial, ok := new(big.Int).SetString(record.Serial, 16)
+		if !ok {
+			s.log.Warnf("Failed to parse revoked certificate serial at %q: %q", item.Key, record.Serial)
+			continue
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: record.RevokedAt,
+		})
+	}
+	return entries, nil
+}
+
+// revokedCertRecord is the JSON value stored for each revoked serial. The
+// serial is kept in the value (not just the key) so listing doesn't
+// depend on how backend.Key joins its components.
+type revokedCertRecord struct {
+	Serial    string    `json:"serial"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+func crlKey(id types.CertAuthID, delta bool) []byte {
+	return backend.Key(crlsPrefix, string(id.Type), id.DomainName, crlVariant(delta))
+}
+
+func crlLockKey(id types.CertAuthID, delta bool) []byte {
+	return backend.Key(crlsPrefix, string(id.Type), id.DomainName, crlVariant(delta), "lock")
+}
+
+func revokedCertKey(id types.CertAuthID, serial *big.Int) []byte {
+	return backend.Key(crlsPrefix, "revoked", string(id.Type), id.DomainName, serial.Text(16))
+}
+
+func revokedCertPrefix(id types.CertAuthID) []byte {
+	return backend.ExactKey(crlsPrefix, "revoked", string(id.Type), id.DomainName)
+}
+
+func crlVariant(delta bool) string {
+	if delta {
+		return "delta"
+	}
+	return "complete"
+}
+
+const (
+	crlsPrefix        = "crls"
+	crlValidityPeriod = 24 * time.Hour
+	crlRebuildLockTTL = 5 * time.Minute
+	// crlReconcileMargin is how far ahead of a CRL's NextUpdate
+	// reconcileStaleCRLs proactively force-rebuilds it.
+	crlReconcileMargin = time.Hour
+)
+
+// RunPeriodicCRLRebuild blocks, rebuilding every forced, delta-forced, or
+// soon-to-expire CRL once per interval, until ctx is canceled. It is the
+// periodic tick crlBuilder's CRLs are designed around: RevokeCertificate
+// only marks a CA's CRL as stale, it doesn't rebuild it, and a CA with no
+// revocation activity would otherwise never have its CRL refreshed
+// before it expires from the backend. The auth server should start this
+// once per process alongside its other background tasks.
+func (s *CA) RunPeriodicCRLRebuild(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.runCRLRebuildTick(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runCRLRebuildTick performs one iteration of RunPeriodicCRLRebuild's
+// loop: proactively mark soon-to-expire CRLs stale, then rebuild
+// everything currently marked stale. Errors are logged rather than
+// returned so that one bad tick doesn't stop the loop.
+func (s *CA) runCRLRebuildTick(ctx context.Context) {
+	if err := s.crl.reconcileStaleCRLs(ctx); err != nil {
+		s.log.Warnf("Failed to reconcile stale CRLs: %v", err)
+	}
+	if warnings, err := s.crl.rebuildIfForced(ctx); err != nil {
+		s.log.Warnf("Failed to rebuild forced CRLs: %v", err)
+	} else {
+		for _, warning := range warnings {
+			s.log.Warn(warning)
+		}
+	}
+	if warnings, err := s.crl.rebuildDeltaCRLsIfForced(ctx, false); err != nil {
+		s.log.Warnf("Failed to rebuild forced delta CRLs: %v", err)
+	} else {
+		for _, warning := range warnings {
+			s.log.Warn(warning)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntriesRevokedSinceFiltersToCutoff(t *testing.T) {
+	cutoff := time.Now()
+	entries := []x509.RevocationListEntry{
+		{SerialNumber: big.NewInt(1), RevocationTime: cutoff.Add(-time.Minute)},
+		{SerialNumber: big.NewInt(2), RevocationTime: cutoff.Add(time.Minute)},
+		{SerialNumber: big.NewInt(3), RevocationTime: cutoff.Add(time.Hour)},
+	}
+
+	since := entriesRevokedSince(entries, cutoff)
+
+	require.Len(t, since, 2)
+	require.Equal(t, 0, big.NewInt(2).Cmp(since[0].SerialNumber))
+	require.Equal(t, 0, big.NewInt(3).Cmp(since[1].SerialNumber))
+}
+
+func TestDeltaCRLIndicatorExtensionCarriesBaseCRLNumber(t *testing.T) {
+	baseNumber := big.NewInt(42)
+
+	ext, err := deltaCRLIndicatorExtension(baseNumber)
+	require.NoError(t, err)
+
+	require.True(t, ext.Id.Equal(deltaCRLIndicatorOID))
+	// RFC 5280 section 5.2.4 requires deltaCRLIndicator be critical, so a
+	// client that doesn't understand delta CRLs rejects it instead of
+	// silently treating it as a complete one.
+	require.True(t, ext.Critical)
+
+	var raw asn1.RawValue
+	_, err = asn1.Unmarshal(ext.Value, &raw)
+	require.NoError(t, err)
+	require.Equal(t, 0, baseNumber.Cmp(new(big.Int).SetBytes(raw.Bytes)))
+}
@@ -0,0 +1,277 @@
+/*
+ * Teleport
+ * Copyright (C) 2024  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gravitational/trace"
+	"google.golang.org/protobuf/proto"
+
+	embeddingpb "github.com/gravitational/teleport/api/gen/proto/go/teleport/embedding/v1"
+	"github.com/gravitational/teleport/api/internalutils/stream"
+	"github.com/gravitational/teleport/lib/backend"
+	"github.com/gravitational/teleport/lib/services"
+	"github.com/gravitational/teleport/lib/services/embeddingindex"
+)
+
+// embeddingsOverfetch is the factor by which QuerySimilar multiplies k
+// when asking the index for candidates under a QueryFilter, so narrowing by label still
+// leaves up to k results without falling back to a full backend scan.
+const embeddingsOverfetch = 4
+
+// LabelResolver looks up the labels of the resource an embedding is
+// attached to, so QuerySimilar can apply a QueryFilter. Embeddings
+// themselves don't carry the labels of the resource they're derived
+// from, and this package has no generic way to reach every resource
+// kind's own store, so the lookup is pluggable instead of assumed.
+type LabelResolver interface {
+	// GetLabels returns the labels of the resourceID of kind, or
+	// trace.NotFound if it no longer exists.
+	GetLabels(ctx context.Context, kind, resourceID string) (map[string]string, error)
+}
+
+// Embeddings is the local backend-backed implementation of
+// services.Embeddings. Each kind gets its own in-memory
+// embeddingindex.Index, lazily built from the backend the first time
+// it's queried and kept in sync on every Upsert, so QuerySimilar never
+// has to stream a kind's embeddings out of the backend to score them.
+type Embeddings struct {
+	backend.Backend
+
+	newIndex func() embeddingindex.Index
+	labels   LabelResolver
+
+	mu      sync.Mutex
+	indexes map[string]embeddingindex.Index
+}
+
+// EmbeddingsOption customizes an Embeddings service built by
+// NewEmbeddingsService.
+type EmbeddingsOption func(*Embeddings)
+
+// WithEmbeddingsIndex overrides the embeddingindex.Index implementation
+// built for each kind. BruteForce scored by cosine similarity is used if
+// unset.
+func WithEmbeddingsIndex(newIndex func() embeddingindex.Index) EmbeddingsOption {
+	return func(e *Embeddings) { e.newIndex = newIndex }
+}
+
+// WithLabelResolver sets the resolver QuerySimilar uses to apply a
+// QueryFilter's label matchers. Without one, a QuerySimilar call that
+// passes a non-empty filter fails with trace.NotImplemented rather than
+// silently ignoring the filter.
+func WithLabelResolver(resolver LabelResolver) EmbeddingsOption {
+	return func(e *Embeddings) { e.labels = resolver }
+}
+
+// NewEmbeddingsService returns a new Embeddings service using backend b.
+func NewEmbeddingsService(b backend.Backend, opts ...EmbeddingsOption) *Embeddings {
+	e := &Embeddings{
+		Backend: b,
+		newIndex: func() embeddingindex.Index {
+			return embeddingindex.NewBruteForce(embeddingindex.MetricCosine)
+		},
+		indexes: make(map[string]embeddingindex.Index),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// GetEmbedding implements services.Embeddings.
+func (e *Embeddings) GetEmbedding(ctx context.Context, kind, resourceID string) (*embeddingpb.Embedding, error) {
+	item, err := e.Get(ctx, embeddingKey(kind, resourceID))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return unmarshalEmbedding(item.Value)
+}
+
+// GetEmbeddings implements services.Embeddings.
+func (e *Embeddings) GetEmbeddings(ctx context.Context, kind string) stream.Stream[*embeddingpb.Embedding] {
+	startKey := embeddingsPrefixKey(kind)
+	result, err := e.GetRange(ctx, startKey, backend.RangeEnd(startKey), backend.NoLimit)
+	if err != nil {
+		return stream.Fail[*embeddingpb.Embedding](trace.Wrap(err))
+	}
+
+	embeddings := make([]*embeddingpb.Embedding, 0, len(result.Items))
+	for _, item := range result.Items {
+		embedding, err := unmarshalEmbedding(item.Value)
+		if err != nil {
+			return stream.Fail[*embeddingpb.Embedding](trace.Wrap(err))
+		}
+		embeddings = append(embeddings, embedding)
+	}
+	return stream.Slice(embeddings)
+}
+
+// UpsertEmbedding implements services.Embeddings.
+func (e *Embeddings) UpsertEmbedding(ctx context.Context, embedding *embeddingpb.Embedding) (*embeddingpb.Embedding, error) {
+	if err := e.UpsertEmbeddings(ctx, []*embeddingpb.Embedding{embedding}); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return embedding, nil
+}
+
+// UpsertEmbeddings implements services.Embeddings.
+func (e *Embeddings) UpsertEmbeddings(ctx context.Context, batch []*embeddingpb.Embedding) error {
+	itemsByKind := make(map[string][]embeddingindex.Item, len(batch))
+	for _, embedding := range batch {
+		value, err := proto.Marshal(embedding)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		_, err = e.Put(ctx, backend.Item{
+			Key:   embeddingKey(embedding.GetEmbeddedKind(), embedding.GetEmbeddedId()),
+			Value: value,
+		})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		itemsByKind[embedding.GetEmbeddedKind()] = append(itemsByKind[embedding.GetEmbeddedKind()], embeddingindex.Item{
+			ID:     embedding.GetEmbeddedId(),
+			Vector: embedding.GetVector(),
+		})
+	}
+
+	for kind, items := range itemsByKind {
+		// A kind that hasn't been queried yet has no index; the first
+		// QuerySimilar builds it from the backend, these items included.
+		e.mu.Lock()
+		index, ok := e.indexes[kind]
+		e.mu.Unlock()
+		if ok {
+			index.Add(items...)
+		}
+	}
+	return nil
+}
+
+// QuerySimilar implements services.Embeddings.
+func (e *Embeddings) QuerySimilar(ctx context.Context, kind string, query []float32, k int, filter *services.QueryFilter) ([]services.ScoredEmbedding, error) {
+	if filter != nil && len(filter.Labels) > 0 && e.labels == nil {
+		return nil, trace.NotImplemented("QuerySimilar called with a label filter but no LabelResolver is configured")
+	}
+
+	index := e.indexFor(kind)
+	if index.Len() == 0 {
+		if err := e.warmIndex(ctx, kind, index); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+
+	searchK := k
+	if filter != nil && len(filter.Labels) > 0 {
+		searchK = k * embeddingsOverfetch
+	}
+
+	scored := make([]services.ScoredEmbedding, 0, k)
+	for _, result := range index.Search(query, searchK) {
+		embedding, err := e.GetEmbedding(ctx, kind, result.ID)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return nil, trace.Wrap(err)
+		}
+
+		if filter != nil && len(filter.Labels) > 0 {
+			labels, err := e.labels.GetLabels(ctx, kind, result.ID)
+			if err != nil {
+				if trace.IsNotFound(err) {
+					continue
+				}
+				return nil, trace.Wrap(err)
+			}
+			if !matchesLabels(labels, filter.Labels) {
+				continue
+			}
+		}
+
+		scored = append(scored, services.ScoredEmbedding{Embedding: embedding, Distance: result.Score})
+		if len(scored) == k {
+			break
+		}
+	}
+	return scored, nil
+}
+
+// warmIndex populates index with every embedding of kind currently in
+// the backend. Called the first time a kind is queried, so the process
+// doesn't pay the cost for kinds it never searches.
+func (e *Embeddings) warmIndex(ctx context.Context, kind string, index embeddingindex.Index) error {
+	startKey := embeddingsPrefixKey(kind)
+	result, err := e.GetRange(ctx, startKey, backend.RangeEnd(startKey), backend.NoLimit)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	items := make([]embeddingindex.Item, 0, len(result.Items))
+	for _, item := range result.Items {
+		embedding, err := unmarshalEmbedding(item.Value)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		items = append(items, embeddingindex.Item{ID: embedding.GetEmbeddedId(), Vector: embedding.GetVector()})
+	}
+	index.Add(items...)
+	return nil
+}
+
+func (e *Embeddings) indexFor(kind string) embeddingindex.Index {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	index, ok := e.indexes[kind]
+	if !ok {
+		index = e.newIndex()
+		e.indexes[kind] = index
+	}
+	return index
+}
+
+func matchesLabels(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func unmarshalEmbedding(value []byte) (*embeddingpb.Embedding, error) {
+	var embedding embeddingpb.Embedding
+	if err := proto.Unmarshal(value, &embedding); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &embedding, nil
+}
+
+func embeddingKey(kind, resourceID string) []byte {
+	return backend.Key(embeddingsPrefix, kind, resourceID)
+}
+
+func embeddingsPrefixKey(kind string) []byte {
+	return backend.ExactKey(embeddingsPrefix, kind)
+}
+
+const embeddingsPrefix = "embeddings"
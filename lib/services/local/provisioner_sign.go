@@ -0,0 +1,170 @@
+/*
+ * Teleport
+ * Copyright (C) 2023  Gravitational, Inc.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package local
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/gravitational/trace"
+
+	"github.com/gravitational/teleport/api/types"
+)
+
+// maxCertSerial bounds the random serial numbers issued by signCSR to
+// 128 bits.
+var maxCertSerial = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// ACMEClient runs the order/challenge/finalize dance against an ACME
+// (RFC 8555) directory on behalf of an ACMEProvisioner. It's an
+// interface, set via WithACMEClient, so SignWithProvisioner doesn't
+// depend on any particular ACME library or require a live ACME server
+// in tests.
+type ACMEClient interface {
+	// ObtainCertificate runs a full ACME order for csr against spec,
+	// validating whatever challenges the directory requires, and
+	// returns the DER-encoded issued certificate followed by any
+	// intermediates the directory returned with it.
+	ObtainCertificate(ctx context.Context, spec types.ACMEProvisionerSpec, csr *x509.CertificateRequest) ([][]byte, error)
+}
+
+// KMSSigner resolves the external key a KMSProvisioner names into a
+// crypto.Signer, so SignWithProvisioner can sign with it without ever
+// holding the private key material itself. Set via WithKMSSigner.
+type KMSSigner interface {
+	// Resolve returns the signer backing keyID, e.g. a GCP KMS
+	// CryptoKeyVersion resource name or an AWS KMS key ARN.
+	Resolve(ctx context.Context, keyID string) (crypto.Signer, error)
+}
+
+// SignWithProvisioner signs csr using the named CAProvisioner attached
+// to the CA identified by id, dispatching to the provisioner's variant
+// (ACME order/challenge, a local JWK, or an external KMS key; Nebula
+// provisioners are not supported and return trace.NotImplemented),
+// instead of assuming the CA's own backend-held SigningKeys are always
+// what issues the certificate. It returns the DER-encoded leaf
+// certificate followed by any intermediates.
+func (s *CA) SignWithProvisioner(ctx context.Context, id types.CertAuthID, provisionerName string, csr *x509.CertificateRequest, notBefore, notAfter time.Time) ([][]byte, error) {
+	provisioner, err := s.LoadProvisionerByName(ctx, id, provisionerName)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	switch p := provisioner.(type) {
+	case *types.ACMEProvisioner:
+		return s.signWithACME(ctx, p, csr)
+	case *types.JWKProvisioner:
+		return s.signWithLocalKey(p.Spec.PrivateKey, p.Spec.Certificate, csr, notBefore, notAfter)
+	case *types.KMSProvisioner:
+		return s.signWithKMS(ctx, p.Spec.KeyID, p.Spec.Certificate, csr, notBefore, notAfter)
+	case *types.NebulaProvisioner:
+		// Nebula certificates aren't X.509: they need their own
+		// encoding and signing path, which nothing in this service
+		// implements yet.
+		return nil, trace.NotImplemented("signing through Nebula provisioner %q is not implemented", provisioner.GetName())
+	default:
+		return nil, trace.BadParameter("unsupported CA provisioner type %T", provisioner)
+	}
+}
+
+func (s *CA) signWithACME(ctx context.Context, provisioner *types.ACMEProvisioner, csr *x509.CertificateRequest) ([][]byte, error) {
+	if s.acmeClient == nil {
+		return nil, trace.NotImplemented("CA service has no ACME client configured, cannot sign through ACME provisioner %q", provisioner.GetName())
+	}
+	chain, err := s.acmeClient.ObtainCertificate(ctx, provisioner.Spec, csr)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return chain, nil
+}
+
+func (s *CA) signWithKMS(ctx context.Context, keyID string, certPEM []byte, csr *x509.CertificateRequest, notBefore, notAfter time.Time) ([][]byte, error) {
+	if s.kmsSigner == nil {
+		return nil, trace.NotImplemented("CA service has no KMS signer configured, cannot sign through KMS key %q", keyID)
+	}
+	signer, err := s.kmsSigner.Resolve(ctx, keyID)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return s.signCSR(signer, certPEM, csr, notBefore, notAfter)
+}
+
+func (s *CA) signWithLocalKey(keyPEM, certPEM []byte, csr *x509.CertificateRequest, notBefore, notAfter time.Time) ([][]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, trace.BadParameter("JWK provisioner private key is not valid PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, trace.BadParameter("JWK provisioner private key of type %T does not implement crypto.Signer", key)
+	}
+	return s.signCSR(signer, certPEM, csr, notBefore, notAfter)
+}
+
+// signCSR issues a leaf certificate for csr, signed by signer, with the
+// provisioner's own issuer certificate (decoded from certPEM) as its
+// parent. The provisioner brings its own signing identity rather than
+// the CA's in-backend SigningKeys precisely because it exists to bypass
+// them, so parent must match signer, not the CA resource.
+func (s *CA) signCSR(signer crypto.Signer, certPEM []byte, csr *x509.CertificateRequest, notBefore, notAfter time.Time) ([][]byte, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, trace.BadParameter("provisioner issuer certificate is not valid PEM")
+	}
+	parent, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, maxCertSerial)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+		URIs:         csr.URIs,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	leaf, err := x509.CreateCertificate(rand.Reader, template, parent, csr.PublicKey, signer)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return [][]byte{leaf, parent.Raw}, nil
+}
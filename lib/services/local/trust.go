@@ -23,6 +23,7 @@ import (
 	"errors"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/gravitational/trace"
 	"github.com/sirupsen/logrus"
@@ -36,17 +37,66 @@ import (
 // is using local backend
 type CA struct {
 	backend.Backend
-	log *logrus.Entry
+	log        *logrus.Entry
+	crl        *crlBuilder
+	compressor caCompressor
+
+	bundleMu       sync.Mutex
+	bundleWatchers map[types.CertAuthType][]*RootCABundleWatcher
+
+	acmeClient ACMEClient
+	kmsSigner  KMSSigner
 }
 
-// NewCAService returns new instance of CAService
-func NewCAService(b backend.Backend) *CA {
-	return &CA{
-		Backend: b,
-		log:     logrus.WithFields(logrus.Fields{trace.Component: "CA"}),
+// CAServiceOption customizes the behavior of a CA returned by
+// NewCAService.
+type CAServiceOption func(*CA)
+
+// WithCACompression opts the service in to transparently gzip-compressing
+// CertAuthority values above the default size threshold before writing
+// them to the backend. Reads understand both compressed and uncompressed
+// items regardless of this setting, so it can be toggled per cluster
+// without a migration.
+func WithCACompression(enabled bool) CAServiceOption {
+	return func(ca *CA) {
+		ca.compressor = newCACompressor(enabled)
+	}
+}
+
+// WithACMEClient sets the client used to fulfil certificate requests
+// routed through an ACMEProvisioner. Without this option, signing
+// through an ACMEProvisioner fails with trace.NotImplemented.
+func WithACMEClient(client ACMEClient) CAServiceOption {
+	return func(ca *CA) {
+		ca.acmeClient = client
+	}
+}
+
+// WithKMSSigner sets the resolver used to obtain a crypto.Signer for
+// certificate requests routed through a KMSProvisioner. Without this
+// option, signing through a KMSProvisioner fails with
+// trace.NotImplemented.
+func WithKMSSigner(signer KMSSigner) CAServiceOption {
+	return func(ca *CA) {
+		ca.kmsSigner = signer
 	}
 }
 
+// NewCAService returns new instance of CAService
+func NewCAService(b backend.Backend, opts ...CAServiceOption) *CA {
+	ca := &CA{
+		Backend:        b,
+		log:            logrus.WithFields(logrus.Fields{trace.Component: "CA"}),
+		compressor:     newCACompressor(false),
+		bundleWatchers: make(map[types.CertAuthType][]*RootCABundleWatcher),
+	}
+	for _, opt := range opts {
+		opt(ca)
+	}
+	ca.crl = newCRLBuilder(ca)
+	return ca
+}
+
 // DeleteAllCertAuthorities deletes all certificate authorities of a certain type
 func (s *CA) DeleteAllCertAuthorities(caType types.CertAuthType) error {
 	// The backend stores CAs like /authorities/<caType>/<name>, so caType is a
@@ -79,6 +129,10 @@ func (s *CA) CreateCertAuthorities(ctx context.Context, cas ...types.CertAuthori
 		if err != nil {
 			return trace.Wrap(err)
 		}
+		value, err = s.compressor.encode(value)
+		if err != nil {
+			return trace.Wrap(err)
+		}
 
 		condacts = append(condacts, []backend.ConditionalAction{
 			{
@@ -105,6 +159,11 @@ func (s *CA) CreateCertAuthorities(ctx context.Context, cas ...types.CertAuthori
 		return trace.Wrap(err)
 	}
 
+	for _, ca := range cas {
+		s.crl.invalidate(ca.GetID())
+		s.notifyRootCABundleWatchers(ca.GetType())
+	}
+
 	return nil
 }
 
@@ -126,6 +185,10 @@ func (s *CA) UpsertCertAuthority(ctx context.Context, ca types.CertAuthority) er
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	value, err = s.compressor.encode(value)
+	if err != nil {
+		return trace.Wrap(err)
+	}
 	item := backend.Item{
 		Key:      activeKey(ca.GetID()),
 		Value:    value,
@@ -138,6 +201,8 @@ func (s *CA) UpsertCertAuthority(ctx context.Context, ca types.CertAuthority) er
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	s.crl.invalidate(ca.GetID())
+	s.notifyRootCABundleWatchers(ca.GetType())
 	return nil
 }
 
@@ -151,6 +216,10 @@ func (s *CA) UpdateCertAuthority(ctx context.Context, ca types.CertAuthority) (t
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
+	value, err = s.compressor.encode(value)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
 
 	item := backend.Item{
 		Key:      activeKey(ca.GetID()),
@@ -168,6 +237,7 @@ func (s *CA) UpdateCertAuthority(ctx context.Context, ca types.CertAuthority) (t
 	ca = ca.Clone()
 	ca.SetRevision(lease.Revision)
 	ca.SetResourceID(lease.ID)
+	s.crl.invalidate(ca.GetID())
 	return ca, nil
 }
 
@@ -237,8 +307,14 @@ func (s *CA) DeleteCertAuthorities(ctx context.Context, ids ...types.CertAuthID)
 		}
 	}
 
-	_, err := s.AtomicWrite(ctx, condacts)
-	return trace.Wrap(err)
+	if _, err := s.AtomicWrite(ctx, condacts); err != nil {
+		return trace.Wrap(err)
+	}
+
+	for _, id := range ids {
+		s.crl.invalidate(id)
+	}
+	return nil
 }
 
 // ActivateCertAuthority moves a CertAuthority from the deactivated list to
@@ -278,6 +354,7 @@ func (s *CA) ActivateCertAuthority(id types.CertAuthID) error {
 		return trace.Wrap(err)
 	}
 
+	s.notifyRootCABundleWatchers(id.Type)
 	return nil
 }
 
@@ -318,6 +395,7 @@ func (s *CA) DeactivateCertAuthority(id types.CertAuthID) error {
 		return trace.Wrap(err)
 	}
 
+	s.notifyRootCABundleWatchers(id.Type)
 	return nil
 }
 
@@ -331,7 +409,11 @@ func (s *CA) GetCertAuthority(ctx context.Context, id types.CertAuthID, loadSign
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	ca, err := services.UnmarshalCertAuthority(item.Value, services.WithResourceID(item.ID), services.WithExpires(item.Expires), services.WithRevision(item.Revision))
+	value, err := s.compressor.decode(item.Value)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	ca, err := services.UnmarshalCertAuthority(value, services.WithResourceID(item.ID), services.WithExpires(item.Expires), services.WithRevision(item.Revision))
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -371,7 +453,12 @@ func (s *CA) GetCertAuthorities(ctx context.Context, caType types.CertAuthType,
 	// Marshal values into a []types.CertAuthority slice.
 	cas := make([]types.CertAuthority, len(result.Items))
 	for i, item := range result.Items {
-		ca, err := services.UnmarshalCertAuthority(item.Value, services.WithResourceID(item.ID), services.WithExpires(item.Expires), services.WithRevision(item.Revision))
+		value, err := s.compressor.decode(item.Value)
+		if err != nil {
+			s.log.Warnf("Failed to decode cert authority at %q: %v", item.Key, err)
+			continue
+		}
+		ca, err := services.UnmarshalCertAuthority(value, services.WithResourceID(item.ID), services.WithExpires(item.Expires), services.WithRevision(item.Revision))
 		if err != nil {
 			s.log.Warnf("Failed to unmarshal cert authority at %q: %v", item.Key, err)
 			continue